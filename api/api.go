@@ -0,0 +1,170 @@
+// Package api is the JSON over HTTP interface to an sbx server. The server
+// exposes a db.Store to clients that hold an sbx session token instead of
+// database credentials, and Client implements db.Store on top of it, so every
+// command works the same against a server as against a local database.
+//
+// Requests other than logging in and registering the first user carry a
+// session or service token as "Authorization: Bearer TOKEN", and the client's
+// hostname as "X-Sbx-Hostname: HOST" for the audit log. Errors are
+// returned as {"error": "message", "code": "..."} where code, when set, names
+// one of the db package's sentinel errors. Errors without a code are failures
+// of the server, answered with 500 and a generic message while the details
+// are logged on the server.
+//
+//	POST   /api/v1/sessions                          log in
+//	GET    /api/v1/session                           the user of the token
+//	DELETE /api/v1/session                           log out
+//	GET    /api/v1/users                             list users
+//	POST   /api/v1/users                             register a user
+//	GET    /api/v1/users/count                       number of users
+//	GET    /api/v1/projects                          list projects
+//	POST   /api/v1/projects                          create a project
+//	GET    /api/v1/projects/{project}/exists
+//	POST   /api/v1/projects/{project}/key-rotations  rotate the data key
+//	GET    /api/v1/projects/{project}/tokens         list service tokens
+//	POST   /api/v1/projects/{project}/tokens         create a service token
+//	DELETE /api/v1/projects/{project}/tokens/{id}    revoke a service token
+//	GET    /api/v1/projects/{project}/members
+//	POST   /api/v1/projects/{project}/members
+//	DELETE /api/v1/projects/{project}/members/{email}
+//	GET    /api/v1/projects/{project}/environments
+//	POST   /api/v1/projects/{project}/environments
+//	GET    /api/v1/projects/{project}/environments/{env}
+//	DELETE /api/v1/projects/{project}/environments/{env}?force=true
+//	PUT    /api/v1/projects/{project}/environments/{env}/parent
+//	GET    /api/v1/projects/{project}/environments/{env}/keys
+//	GET    /api/v1/projects/{project}/environments/{env}/key-completions  keys, not audited
+//	GET    /api/v1/projects/{project}/environments/{env}/secrets
+//	POST   /api/v1/projects/{project}/environments/{env}/secrets
+//	GET    /api/v1/projects/{project}/environments/{env}/secrets/{key}/exists?location=
+//	PUT    /api/v1/projects/{project}/environments/{env}/secrets/{key}
+//	DELETE /api/v1/projects/{project}/environments/{env}/secrets/{key}?location=
+//	GET    /api/v1/projects/{project}/environments/{env}/secrets/{key}/versions?location=
+//	POST   /api/v1/projects/{project}/environments/{env}/secrets/{key}/rollbacks
+//	POST   /api/v1/projects/{project}/environments/{env}/changesets
+//	POST   /api/v1/projects/{project}/environments/{env}/rollbacks
+//	GET    /api/v1/audit?project=&environment=&actor=&since=&until=&limit=
+package api
+
+import (
+	"errors"
+	"time"
+
+	"github.com/spf13/sbx/db"
+)
+
+// maxBodySize caps request bodies, leaving room for large changesets
+const maxBodySize = 10 << 20
+
+// hostnameHeader carries the hostname of the client, which audit events
+// record instead of the server's
+const hostnameHeader = "X-Sbx-Hostname"
+
+// maxHostnameLength caps the hostname a client reports, as DNS does
+const maxHostnameLength = 253
+
+// Error codes of the sentinel errors that clients check for with errors.Is
+var errorCodes = map[string]error{
+	"invalid_credentials":   db.ErrInvalidCredentials,
+	"invalid_session":       db.ErrInvalidSession,
+	"invalid_token":         db.ErrInvalidToken,
+	"permission_denied":     db.ErrPermissionDenied,
+	"environment_not_found": db.ErrEnvironmentNotFound,
+	"invalid_request":       db.ErrInvalidRequest,
+}
+
+// errorResponse is the body of every failed request
+type errorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
+}
+
+// remoteError is an error returned by the server. It matches the sentinel
+// error named by its code with errors.Is.
+type remoteError struct {
+	message  string
+	sentinel error
+}
+
+func (e *remoteError) Error() string { return e.message }
+func (e *remoteError) Unwrap() error { return e.sentinel }
+
+// errorCode returns the code of the sentinel error wrapped by err, if any
+func errorCode(err error) string {
+	for code, sentinel := range errorCodes {
+		if errors.Is(err, sentinel) {
+			return code
+		}
+	}
+	return ""
+}
+
+type loginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type loginResponse struct {
+	User      db.User   `json:"user"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type createUserRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Admin    bool   `json:"admin"`
+}
+
+type countResponse struct {
+	Count int `json:"count"`
+}
+
+type existsResponse struct {
+	Exists bool `json:"exists"`
+}
+
+type createProjectRequest struct {
+	Name string `json:"name"`
+}
+
+type rotateKeyResponse struct {
+	Version int `json:"version"`
+}
+
+type createTokenRequest struct {
+	Environment string        `json:"environment"`
+	Name        string        `json:"name"`
+	ReadOnly    bool          `json:"read_only"`
+	TTL         time.Duration `json:"ttl"`
+}
+
+type createTokenResponse struct {
+	Token string          `json:"token"`
+	Info  db.ServiceToken `json:"info"`
+}
+
+type addMemberRequest struct {
+	Email        string   `json:"email"`
+	Role         db.Role  `json:"role"`
+	Environments []string `json:"environments"`
+}
+
+type setParentRequest struct {
+	Parent string `json:"parent"`
+}
+
+type secretRequest struct {
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	Location string `json:"location"`
+}
+
+type rollbackSecretRequest struct {
+	Location string `json:"location"`
+	Version  int    `json:"version"`
+}
+
+type rollbackEnvironmentRequest struct {
+	At time.Time `json:"at"`
+}
@@ -0,0 +1,356 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/sbx/db"
+)
+
+// errMigrationsRemote is returned by the schema operations of a Client
+var errMigrationsRemote = errors.New("schema migrations are run on the sbx server, not through --remote")
+
+// Client is a db.Store that talks to an sbx server. The server acts as the
+// user owning the client's session or service token, so the actor arguments
+// of the Store methods are ignored.
+type Client struct {
+	baseURL  string
+	token    string
+	hostname string
+	http     *http.Client
+}
+
+var _ db.Store = (*Client)(nil)
+
+// NewClient returns a client for the server at baseURL, authenticated with a
+// session or service token. The token may be empty until Login is called.
+func NewClient(baseURL, token string) *Client {
+	hostname, _ := os.Hostname()
+	return &Client{
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		token:    token,
+		hostname: hostname,
+		http:     &http.Client{Timeout: time.Minute},
+	}
+}
+
+// projectPath returns the API path of a project, followed by the escaped elements
+func projectPath(projectName string, elements ...string) string {
+	path := "/api/v1/projects/" + url.PathEscape(projectName)
+	for _, element := range elements {
+		path += "/" + url.PathEscape(element)
+	}
+	return path
+}
+
+// locationQuery returns the query selecting a secret's location
+func locationQuery(location string) url.Values {
+	return url.Values{"location": {location}}
+}
+
+// do sends a request with a JSON body, when body is not nil, and decodes the
+// JSON response into out, when out is not nil
+func (c *Client) do(method, path string, query url.Values, body, out any) error {
+	return c.doWithToken(c.token, method, path, query, body, out)
+}
+
+func (c *Client) doWithToken(token, method, path string, query url.Values, body, out any) error {
+	endpoint := c.baseURL + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("error encoding request: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, endpoint, reader)
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if c.hostname != "" {
+		req.Header.Set(hostnameHeader, c.hostname)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("error contacting the sbx server: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return responseError(resp)
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("error decoding response from the sbx server: %v", err)
+	}
+	return nil
+}
+
+// responseError turns a failed response into an error, keeping the sentinel
+// error named by its code
+func responseError(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+	var body errorResponse
+	if err := json.Unmarshal(data, &body); err != nil || body.Error == "" {
+		message := strings.TrimSpace(string(data))
+		if message == "" {
+			message = resp.Status
+		}
+		return fmt.Errorf("sbx server error: %s", message)
+	}
+	return &remoteError{message: body.Error, sentinel: errorCodes[body.Code]}
+}
+
+// CreateUser registers a user. Unless the server has no users yet, the
+// client must be logged in as an admin.
+func (c *Client) CreateUser(email, password string, admin bool) error {
+	return c.do(http.MethodPost, "/api/v1/users", nil, createUserRequest{Email: email, Password: password, Admin: admin}, nil)
+}
+
+func (c *Client) CountUsers() (int, error) {
+	var resp countResponse
+	err := c.do(http.MethodGet, "/api/v1/users/count", nil, nil, &resp)
+	return resp.Count, err
+}
+
+func (c *Client) ListUsers(actor db.User) ([]db.User, error) {
+	var users []db.User
+	err := c.do(http.MethodGet, "/api/v1/users", nil, nil, &users)
+	return users, err
+}
+
+// Login starts a session on the server and uses its token for the requests
+// that follow
+func (c *Client) Login(email, password string) (db.User, string, time.Time, error) {
+	var resp loginResponse
+	err := c.do(http.MethodPost, "/api/v1/sessions", nil, loginRequest{Email: email, Password: password}, &resp)
+	if err != nil {
+		return db.User{}, "", time.Time{}, err
+	}
+	c.token = resp.Token
+	return resp.User, resp.Token, resp.ExpiresAt, nil
+}
+
+func (c *Client) GetSessionUser(token string) (db.User, error) {
+	var user db.User
+	err := c.doWithToken(token, http.MethodGet, "/api/v1/session", nil, nil, &user)
+	return user, err
+}
+
+func (c *Client) DeleteSession(token string) error {
+	return c.doWithToken(token, http.MethodDelete, "/api/v1/session", nil, nil, nil)
+}
+
+func (c *Client) CreateProject(creator db.User, name string) error {
+	return c.do(http.MethodPost, "/api/v1/projects", nil, createProjectRequest{Name: name}, nil)
+}
+
+func (c *Client) ProjectExists(name string) (bool, error) {
+	var resp existsResponse
+	err := c.do(http.MethodGet, projectPath(name, "exists"), nil, nil, &resp)
+	return resp.Exists, err
+}
+
+func (c *Client) ListProjects(actor db.User) ([]db.Project, error) {
+	var projects []db.Project
+	err := c.do(http.MethodGet, "/api/v1/projects", nil, nil, &projects)
+	return projects, err
+}
+
+func (c *Client) RotateProjectKey(actor db.User, projectName string) (int, error) {
+	var resp rotateKeyResponse
+	err := c.do(http.MethodPost, projectPath(projectName, "key-rotations"), nil, nil, &resp)
+	return resp.Version, err
+}
+
+func (c *Client) CreateServiceToken(actor db.User, projectName, environmentType, name string, readOnly bool, ttl time.Duration) (string, db.ServiceToken, error) {
+	req := createTokenRequest{Environment: environmentType, Name: name, ReadOnly: readOnly, TTL: ttl}
+	var resp createTokenResponse
+	err := c.do(http.MethodPost, projectPath(projectName, "tokens"), nil, req, &resp)
+	return resp.Token, resp.Info, err
+}
+
+func (c *Client) ListServiceTokens(actor db.User, projectName string) ([]db.ServiceToken, error) {
+	var tokens []db.ServiceToken
+	err := c.do(http.MethodGet, projectPath(projectName, "tokens"), nil, nil, &tokens)
+	return tokens, err
+}
+
+func (c *Client) RevokeServiceToken(actor db.User, projectName string, id int) error {
+	return c.do(http.MethodDelete, projectPath(projectName, "tokens", strconv.Itoa(id)), nil, nil, nil)
+}
+
+func (c *Client) AddMember(actor db.User, projectName, email string, role db.Role, environments []string) error {
+	req := addMemberRequest{Email: email, Role: role, Environments: environments}
+	return c.do(http.MethodPost, projectPath(projectName, "members"), nil, req, nil)
+}
+
+func (c *Client) RemoveMember(actor db.User, projectName, email string) error {
+	return c.do(http.MethodDelete, projectPath(projectName, "members", email), nil, nil, nil)
+}
+
+func (c *Client) ListMembers(actor db.User, projectName string) ([]db.Member, error) {
+	var members []db.Member
+	err := c.do(http.MethodGet, projectPath(projectName, "members"), nil, nil, &members)
+	return members, err
+}
+
+func (c *Client) GetEnvironment(projectName, name string) (db.Environment, error) {
+	var env db.Environment
+	err := c.do(http.MethodGet, projectPath(projectName, "environments", name), nil, nil, &env)
+	return env, err
+}
+
+func (c *Client) ListEnvironments(actor db.User, projectName string) ([]db.Environment, error) {
+	var envs []db.Environment
+	err := c.do(http.MethodGet, projectPath(projectName, "environments"), nil, nil, &envs)
+	return envs, err
+}
+
+func (c *Client) CreateEnvironment(actor db.User, projectName string, env db.Environment) error {
+	return c.do(http.MethodPost, projectPath(projectName, "environments"), nil, env, nil)
+}
+
+func (c *Client) SetEnvironmentParent(actor db.User, projectName, name, parentName string) error {
+	return c.do(http.MethodPut, projectPath(projectName, "environments", name, "parent"), nil, setParentRequest{Parent: parentName}, nil)
+}
+
+func (c *Client) DeleteEnvironment(actor db.User, projectName, name string, force bool) error {
+	query := url.Values{"force": {strconv.FormatBool(force)}}
+	return c.do(http.MethodDelete, projectPath(projectName, "environments", name), query, nil, nil)
+}
+
+func (c *Client) SecretExists(actor db.User, key, location, projectName, environmentType string) (bool, error) {
+	var resp existsResponse
+	path := projectPath(projectName, "environments", environmentType, "secrets", key, "exists")
+	err := c.do(http.MethodGet, path, locationQuery(location), nil, &resp)
+	return resp.Exists, err
+}
+
+func (c *Client) CreateSecret(creator db.User, key, value, location, projectName, environmentType string) error {
+	req := secretRequest{Key: key, Value: value, Location: location}
+	return c.do(http.MethodPost, projectPath(projectName, "environments", environmentType, "secrets"), nil, req, nil)
+}
+
+func (c *Client) UpdateSecret(editor db.User, key, value, location, projectName, environmentType string) error {
+	req := secretRequest{Key: key, Value: value, Location: location}
+	return c.do(http.MethodPut, projectPath(projectName, "environments", environmentType, "secrets", key), nil, req, nil)
+}
+
+func (c *Client) DeleteSecret(actor db.User, key, location, projectName, environmentType string) error {
+	path := projectPath(projectName, "environments", environmentType, "secrets", key)
+	return c.do(http.MethodDelete, path, locationQuery(location), nil, nil)
+}
+
+func (c *Client) GetAllSecretsKeys(actor db.User, projectName, environmentType string) ([]string, error) {
+	var keys []string
+	err := c.do(http.MethodGet, projectPath(projectName, "environments", environmentType, "keys"), nil, nil, &keys)
+	return keys, err
+}
+
+func (c *Client) CompleteKeys(actor db.User, projectName, environmentType string) ([]string, error) {
+	var keys []string
+	err := c.do(http.MethodGet, projectPath(projectName, "environments", environmentType, "key-completions"), nil, nil, &keys)
+	return keys, err
+}
+
+func (c *Client) GetSecrets(actor db.User, projectName, environmentType string) ([]db.Secret, error) {
+	var secrets []db.Secret
+	err := c.do(http.MethodGet, projectPath(projectName, "environments", environmentType, "secrets"), nil, nil, &secrets)
+	return secrets, err
+}
+
+func (c *Client) ApplyChangeset(actor db.User, projectName, environmentType string, changeset db.Changeset) error {
+	return c.do(http.MethodPost, projectPath(projectName, "environments", environmentType, "changesets"), nil, changeset, nil)
+}
+
+func (c *Client) SecretHistory(actor db.User, key, location, projectName, environmentType string) ([]db.SecretVersion, error) {
+	var versions []db.SecretVersion
+	path := projectPath(projectName, "environments", environmentType, "secrets", key, "versions")
+	err := c.do(http.MethodGet, path, locationQuery(location), nil, &versions)
+	return versions, err
+}
+
+func (c *Client) RollbackSecret(actor db.User, key, location, projectName, environmentType string, version int) error {
+	path := projectPath(projectName, "environments", environmentType, "secrets", key, "rollbacks")
+	return c.do(http.MethodPost, path, nil, rollbackSecretRequest{Location: location, Version: version}, nil)
+}
+
+func (c *Client) RollbackEnvironment(actor db.User, projectName, environmentType string, at time.Time) ([]db.SecretRef, error) {
+	var restored []db.SecretRef
+	path := projectPath(projectName, "environments", environmentType, "rollbacks")
+	err := c.do(http.MethodPost, path, nil, rollbackEnvironmentRequest{At: at}, &restored)
+	return restored, err
+}
+
+func (c *Client) ListAuditEvents(actor db.User, filter db.AuditFilter) ([]db.AuditEvent, error) {
+	query := url.Values{}
+	if filter.Project != "" {
+		query.Set("project", filter.Project)
+	}
+	if filter.Environment != "" {
+		query.Set("environment", filter.Environment)
+	}
+	if filter.Actor != "" {
+		query.Set("actor", filter.Actor)
+	}
+	if !filter.Since.IsZero() {
+		query.Set("since", filter.Since.Format(time.RFC3339Nano))
+	}
+	if !filter.Until.IsZero() {
+		query.Set("until", filter.Until.Format(time.RFC3339Nano))
+	}
+	if filter.Limit > 0 {
+		query.Set("limit", strconv.Itoa(filter.Limit))
+	}
+
+	var events []db.AuditEvent
+	err := c.do(http.MethodGet, "/api/v1/audit", query, nil, &events)
+	return events, err
+}
+
+// MigrationStatus is not available remotely, the server manages its schema
+func (c *Client) MigrationStatus() ([]db.Migration, error) {
+	return nil, errMigrationsRemote
+}
+
+// PendingMigrations reports none, as the server refuses to start with
+// pending migrations
+func (c *Client) PendingMigrations() ([]db.Migration, error) {
+	return nil, nil
+}
+
+// Migrate is not available remotely, the server manages its schema
+func (c *Client) Migrate() ([]db.Migration, error) {
+	return nil, errMigrationsRemote
+}
+
+func (c *Client) Close() error {
+	c.http.CloseIdleConnections()
+	return nil
+}
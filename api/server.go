@@ -0,0 +1,448 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/sbx/db"
+)
+
+// Server serves the API on top of a store. The store checks the permissions
+// of the user making each request, so the server only has to authenticate
+// them.
+type Server struct {
+	store db.Store
+	mux   *http.ServeMux
+}
+
+// authedHandler handles a request made by an authenticated user. A nil result
+// is sent as 204 No Content.
+type authedHandler func(r *http.Request, actor db.User) (any, error)
+
+// NewServer returns a server for the store
+func NewServer(store db.Store) *Server {
+	s := &Server{store: store, mux: http.NewServeMux()}
+
+	s.mux.HandleFunc("POST /api/v1/sessions", s.handleLogin)
+	s.mux.HandleFunc("POST /api/v1/users", s.handleCreateUser)
+	s.mux.HandleFunc("GET /api/v1/users/count", s.handleCountUsers)
+
+	s.handle("GET /api/v1/session", s.getSession)
+	s.handle("DELETE /api/v1/session", s.deleteSession)
+	s.handle("GET /api/v1/users", s.listUsers)
+
+	s.handle("GET /api/v1/projects", s.listProjects)
+	s.handle("POST /api/v1/projects", s.createProject)
+	s.handle("GET /api/v1/projects/{project}/exists", s.projectExists)
+	s.handle("POST /api/v1/projects/{project}/key-rotations", s.rotateKey)
+	s.handle("GET /api/v1/projects/{project}/tokens", s.listTokens)
+	s.handle("POST /api/v1/projects/{project}/tokens", s.createToken)
+	s.handle("DELETE /api/v1/projects/{project}/tokens/{id}", s.revokeToken)
+	s.handle("GET /api/v1/projects/{project}/members", s.listMembers)
+	s.handle("POST /api/v1/projects/{project}/members", s.addMember)
+	s.handle("DELETE /api/v1/projects/{project}/members/{email}", s.removeMember)
+
+	s.handle("GET /api/v1/projects/{project}/environments", s.listEnvironments)
+	s.handle("POST /api/v1/projects/{project}/environments", s.createEnvironment)
+	s.handle("GET /api/v1/projects/{project}/environments/{env}", s.getEnvironment)
+	s.handle("DELETE /api/v1/projects/{project}/environments/{env}", s.deleteEnvironment)
+	s.handle("PUT /api/v1/projects/{project}/environments/{env}/parent", s.setParent)
+
+	s.handle("GET /api/v1/projects/{project}/environments/{env}/keys", s.listKeys)
+	s.handle("GET /api/v1/projects/{project}/environments/{env}/key-completions", s.completeKeys)
+	s.handle("GET /api/v1/projects/{project}/environments/{env}/secrets", s.getSecrets)
+	s.handle("POST /api/v1/projects/{project}/environments/{env}/secrets", s.createSecret)
+	s.handle("GET /api/v1/projects/{project}/environments/{env}/secrets/{key}/exists", s.secretExists)
+	s.handle("PUT /api/v1/projects/{project}/environments/{env}/secrets/{key}", s.updateSecret)
+	s.handle("DELETE /api/v1/projects/{project}/environments/{env}/secrets/{key}", s.deleteSecret)
+	s.handle("GET /api/v1/projects/{project}/environments/{env}/secrets/{key}/versions", s.secretHistory)
+	s.handle("POST /api/v1/projects/{project}/environments/{env}/secrets/{key}/rollbacks", s.rollbackSecret)
+	s.handle("POST /api/v1/projects/{project}/environments/{env}/changesets", s.applyChangeset)
+	s.handle("POST /api/v1/projects/{project}/environments/{env}/rollbacks", s.rollbackEnvironment)
+
+	s.handle("GET /api/v1/audit", s.listAuditEvents)
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// handle registers a handler that requires a valid session token
+func (s *Server) handle(pattern string, handler authedHandler) {
+	s.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		actor, err := s.authenticate(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+		result, err := handler(r, actor)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
+	})
+}
+
+// authenticate returns the user owning the request's bearer token, acting
+// from the host the request came from
+func (s *Server) authenticate(r *http.Request) (db.User, error) {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || token == "" {
+		return db.User{}, db.ErrInvalidSession
+	}
+	user, err := s.store.GetSessionUser(token)
+	if err != nil {
+		return db.User{}, err
+	}
+	user.Hostname = clientHostname(r)
+	return user, nil
+}
+
+// clientHostname returns the hostname the client reports, or else its
+// address, so that audit events never name the server as the client
+func clientHostname(r *http.Request) string {
+	if hostname := strings.TrimSpace(r.Header.Get(hostnameHeader)); hostname != "" {
+		if len(hostname) > maxHostnameLength {
+			hostname = hostname[:maxHostnameLength]
+		}
+		return hostname
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// writeJSON sends a JSON response, or 204 No Content for a nil value
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	if value == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// writeError sends an error response with a status matching the error. Any
+// error not caused by the request may hold details of the database, so
+// clients only get a generic message and the error is logged instead.
+func writeError(w http.ResponseWriter, err error) {
+	code := errorCode(err)
+	status := http.StatusInternalServerError
+	switch code {
+	case "invalid_credentials", "invalid_session", "invalid_token":
+		status = http.StatusUnauthorized
+	case "permission_denied":
+		status = http.StatusForbidden
+	case "environment_not_found":
+		status = http.StatusNotFound
+	case "invalid_request":
+		status = http.StatusBadRequest
+	}
+
+	if status == http.StatusInternalServerError {
+		log.Printf("Internal server error: %v", err)
+		writeJSON(w, status, errorResponse{Error: "internal server error, see the sbx server log"})
+		return
+	}
+	writeJSON(w, status, errorResponse{Error: err.Error(), Code: code})
+}
+
+// decode reads a JSON request body into v
+func decode(r *http.Request, v any) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return fmt.Errorf("%w: the body is not valid JSON: %v", db.ErrInvalidRequest, err)
+	}
+	return nil
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	var req loginRequest
+	if err := decode(r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	user, token, expiresAt, err := s.store.Login(req.Email, req.Password)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, loginResponse{User: user, Token: token, ExpiresAt: expiresAt})
+}
+
+// handleCreateUser registers a user. The first user needs no session and
+// always becomes an admin, after that only admins may register users.
+func (s *Server) handleCreateUser(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	var req createUserRequest
+	if err := decode(r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	count, err := s.store.CountUsers()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if count == 0 {
+		req.Admin = true
+	} else {
+		actor, err := s.authenticate(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if !actor.Admin {
+			writeError(w, fmt.Errorf("%w: only admins can register users", db.ErrPermissionDenied))
+			return
+		}
+	}
+
+	if err := s.store.CreateUser(req.Email, req.Password, req.Admin); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, struct{}{})
+}
+
+func (s *Server) handleCountUsers(w http.ResponseWriter, r *http.Request) {
+	count, err := s.store.CountUsers()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, countResponse{Count: count})
+}
+
+func (s *Server) getSession(r *http.Request, actor db.User) (any, error) {
+	return actor, nil
+}
+
+func (s *Server) deleteSession(r *http.Request, actor db.User) (any, error) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return nil, s.store.DeleteSession(token)
+}
+
+func (s *Server) listUsers(r *http.Request, actor db.User) (any, error) {
+	return s.store.ListUsers(actor)
+}
+
+func (s *Server) listProjects(r *http.Request, actor db.User) (any, error) {
+	return s.store.ListProjects(actor)
+}
+
+func (s *Server) createProject(r *http.Request, actor db.User) (any, error) {
+	var req createProjectRequest
+	if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+	return nil, s.store.CreateProject(actor, req.Name)
+}
+
+func (s *Server) projectExists(r *http.Request, actor db.User) (any, error) {
+	exists, err := s.store.ProjectExists(r.PathValue("project"))
+	if err != nil {
+		return nil, err
+	}
+	return existsResponse{Exists: exists}, nil
+}
+
+func (s *Server) rotateKey(r *http.Request, actor db.User) (any, error) {
+	version, err := s.store.RotateProjectKey(actor, r.PathValue("project"))
+	if err != nil {
+		return nil, err
+	}
+	return rotateKeyResponse{Version: version}, nil
+}
+
+func (s *Server) listTokens(r *http.Request, actor db.User) (any, error) {
+	return s.store.ListServiceTokens(actor, r.PathValue("project"))
+}
+
+func (s *Server) createToken(r *http.Request, actor db.User) (any, error) {
+	var req createTokenRequest
+	if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+	token, info, err := s.store.CreateServiceToken(actor, r.PathValue("project"), req.Environment, req.Name, req.ReadOnly, req.TTL)
+	if err != nil {
+		return nil, err
+	}
+	return createTokenResponse{Token: token, Info: info}, nil
+}
+
+func (s *Server) revokeToken(r *http.Request, actor db.User) (any, error) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: token ID %q is not a number", db.ErrInvalidRequest, r.PathValue("id"))
+	}
+	return nil, s.store.RevokeServiceToken(actor, r.PathValue("project"), id)
+}
+
+func (s *Server) listMembers(r *http.Request, actor db.User) (any, error) {
+	return s.store.ListMembers(actor, r.PathValue("project"))
+}
+
+func (s *Server) addMember(r *http.Request, actor db.User) (any, error) {
+	var req addMemberRequest
+	if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+	return nil, s.store.AddMember(actor, r.PathValue("project"), req.Email, req.Role, req.Environments)
+}
+
+func (s *Server) removeMember(r *http.Request, actor db.User) (any, error) {
+	return nil, s.store.RemoveMember(actor, r.PathValue("project"), r.PathValue("email"))
+}
+
+func (s *Server) listEnvironments(r *http.Request, actor db.User) (any, error) {
+	return s.store.ListEnvironments(actor, r.PathValue("project"))
+}
+
+func (s *Server) createEnvironment(r *http.Request, actor db.User) (any, error) {
+	var env db.Environment
+	if err := decode(r, &env); err != nil {
+		return nil, err
+	}
+	return nil, s.store.CreateEnvironment(actor, r.PathValue("project"), env)
+}
+
+// getEnvironment goes through ListEnvironments, since GetEnvironment itself
+// does not check that the actor may read the project
+func (s *Server) getEnvironment(r *http.Request, actor db.User) (any, error) {
+	projectName, name := r.PathValue("project"), r.PathValue("env")
+	envs, err := s.store.ListEnvironments(actor, projectName)
+	if err != nil {
+		return nil, err
+	}
+	for _, env := range envs {
+		if env.Name == name {
+			return env, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: project '%s' has no '%s' environment", db.ErrEnvironmentNotFound, projectName, name)
+}
+
+func (s *Server) deleteEnvironment(r *http.Request, actor db.User) (any, error) {
+	force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+	return nil, s.store.DeleteEnvironment(actor, r.PathValue("project"), r.PathValue("env"), force)
+}
+
+func (s *Server) setParent(r *http.Request, actor db.User) (any, error) {
+	var req setParentRequest
+	if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+	return nil, s.store.SetEnvironmentParent(actor, r.PathValue("project"), r.PathValue("env"), req.Parent)
+}
+
+func (s *Server) listKeys(r *http.Request, actor db.User) (any, error) {
+	return s.store.GetAllSecretsKeys(actor, r.PathValue("project"), r.PathValue("env"))
+}
+
+func (s *Server) completeKeys(r *http.Request, actor db.User) (any, error) {
+	return s.store.CompleteKeys(actor, r.PathValue("project"), r.PathValue("env"))
+}
+
+func (s *Server) getSecrets(r *http.Request, actor db.User) (any, error) {
+	return s.store.GetSecrets(actor, r.PathValue("project"), r.PathValue("env"))
+}
+
+func (s *Server) createSecret(r *http.Request, actor db.User) (any, error) {
+	var req secretRequest
+	if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+	return nil, s.store.CreateSecret(actor, req.Key, req.Value, req.Location, r.PathValue("project"), r.PathValue("env"))
+}
+
+func (s *Server) secretExists(r *http.Request, actor db.User) (any, error) {
+	exists, err := s.store.SecretExists(actor, r.PathValue("key"), r.URL.Query().Get("location"), r.PathValue("project"), r.PathValue("env"))
+	if err != nil {
+		return nil, err
+	}
+	return existsResponse{Exists: exists}, nil
+}
+
+func (s *Server) updateSecret(r *http.Request, actor db.User) (any, error) {
+	var req secretRequest
+	if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+	return nil, s.store.UpdateSecret(actor, r.PathValue("key"), req.Value, req.Location, r.PathValue("project"), r.PathValue("env"))
+}
+
+func (s *Server) deleteSecret(r *http.Request, actor db.User) (any, error) {
+	return nil, s.store.DeleteSecret(actor, r.PathValue("key"), r.URL.Query().Get("location"), r.PathValue("project"), r.PathValue("env"))
+}
+
+func (s *Server) secretHistory(r *http.Request, actor db.User) (any, error) {
+	return s.store.SecretHistory(actor, r.PathValue("key"), r.URL.Query().Get("location"), r.PathValue("project"), r.PathValue("env"))
+}
+
+func (s *Server) rollbackSecret(r *http.Request, actor db.User) (any, error) {
+	var req rollbackSecretRequest
+	if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+	return nil, s.store.RollbackSecret(actor, r.PathValue("key"), req.Location, r.PathValue("project"), r.PathValue("env"), req.Version)
+}
+
+func (s *Server) applyChangeset(r *http.Request, actor db.User) (any, error) {
+	var changeset db.Changeset
+	if err := decode(r, &changeset); err != nil {
+		return nil, err
+	}
+	return nil, s.store.ApplyChangeset(actor, r.PathValue("project"), r.PathValue("env"), changeset)
+}
+
+func (s *Server) rollbackEnvironment(r *http.Request, actor db.User) (any, error) {
+	var req rollbackEnvironmentRequest
+	if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+	return s.store.RollbackEnvironment(actor, r.PathValue("project"), r.PathValue("env"), req.At)
+}
+
+func (s *Server) listAuditEvents(r *http.Request, actor db.User) (any, error) {
+	query := r.URL.Query()
+	filter := db.AuditFilter{
+		Project:     query.Get("project"),
+		Environment: query.Get("environment"),
+		Actor:       query.Get("actor"),
+	}
+
+	var err error
+	if since := query.Get("since"); since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339Nano, since); err != nil {
+			return nil, fmt.Errorf("%w: since: %v", db.ErrInvalidRequest, err)
+		}
+	}
+	if until := query.Get("until"); until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339Nano, until); err != nil {
+			return nil, fmt.Errorf("%w: until: %v", db.ErrInvalidRequest, err)
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil {
+			return nil, fmt.Errorf("%w: limit: %v", db.ErrInvalidRequest, err)
+		}
+	}
+
+	return s.store.ListAuditEvents(actor, filter)
+}
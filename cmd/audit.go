@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+
+	dbpkg "github.com/spf13/sbx/db"
+	"github.com/spf13/sbx/helpers"
+)
+
+// auditCmd represents the audit command
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Show the audit log of secret reads and writes",
+	Long: `The audit command lists who read or changed secrets, newest first.
+Events can be filtered by project, environment, user and time range.
+--since and --until accept RFC 3339 timestamps, dates (YYYY-MM-DD) or
+durations such as 24h or 7d meaning that long ago.
+With --json every event is printed as one JSON object per line, ready to be
+shipped to a SIEM.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		projectName, _ := cmd.Flags().GetString("project")
+		environmentType, _ := cmd.Flags().GetString("env")
+		actor, _ := cmd.Flags().GetString("user")
+		since, _ := cmd.Flags().GetString("since")
+		until, _ := cmd.Flags().GetString("until")
+		limit, _ := cmd.Flags().GetInt("limit")
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		filter := dbpkg.AuditFilter{
+			Project:     projectName,
+			Environment: environmentType,
+			Actor:       actor,
+			Limit:       limit,
+		}
+
+		var err error
+		if since != "" {
+			filter.Since, err = helpers.ParseTime(since)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid --since: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if until != "" {
+			filter.Until, err = helpers.ParseTime(until)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid --until: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		db, err := connectStore()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to connect to the database: %v\n", err)
+			os.Exit(1)
+		}
+		defer db.Close()
+
+		// Only authenticated users may proceed
+		user := requireUser(db)
+
+		events, err := db.ListAuditEvents(user, filter)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to fetch audit events: %v\n", err)
+			os.Exit(1)
+		}
+
+		if asJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			for _, event := range events {
+				if err := encoder.Encode(event); err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to encode audit event: %v\n", err)
+					os.Exit(1)
+				}
+			}
+			return
+		}
+
+		// Create a table to display the results
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Time", "User", "Action", "Project", "Environment", "Key", "Host"})
+
+		for _, event := range events {
+			table.Append([]string{
+				event.CreatedAt.Local().Format("2006-01-02 15:04:05"),
+				event.Actor,
+				string(event.Action),
+				event.Project,
+				event.Environment,
+				event.Key,
+				event.Hostname,
+			})
+		}
+
+		// Render the table to stdout
+		table.Render()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+
+	// Flags for the audit command
+	auditCmd.Flags().StringP("project", "p", "", "Only show events for this project")
+	auditCmd.Flags().StringP("env", "e", "", "Only show events for this environment")
+	auditCmd.Flags().StringP("user", "u", "", "Only show events by this user (email)")
+	auditCmd.Flags().String("since", "", "Only show events at or after this time")
+	auditCmd.Flags().String("until", "", "Only show events at or before this time")
+	auditCmd.Flags().IntP("limit", "n", 100, "Maximum number of events to show (0 for no limit)")
+	auditCmd.Flags().Bool("json", false, "Print events as JSON lines")
+}
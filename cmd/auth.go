@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/sbx/api"
+	dbpkg "github.com/spf13/sbx/db"
+	"github.com/spf13/sbx/helpers"
+)
+
+// remoteFlag is the sbx server given with --remote
+var remoteFlag string
+
+// remoteURL returns the sbx server commands talk to, from --remote or
+// SBX_REMOTE, or an empty string to use the database directly
+func remoteURL() string {
+	remote := remoteFlag
+	if remote == "" {
+		remote = os.Getenv("SBX_REMOTE")
+	}
+	return strings.TrimSuffix(remote, "/")
+}
+
+// backendName describes where a session or command's data lives
+func backendName(remote string) string {
+	if remote == "" {
+		return "the local database"
+	}
+	return remote
+}
+
+// connectStore connects to the sbx server given with --remote, or to the
+// configured database without one
+func connectStore() (dbpkg.Store, error) {
+	return connectTo(remoteURL())
+}
+
+// connectTo connects to an sbx server, or to the configured database when
+// remote is empty. The server is sent the service token in SBX_TOKEN, or
+// else the cached session token, but only if that server issued it.
+func connectTo(remote string) (dbpkg.Store, error) {
+	if remote == "" {
+		return dbpkg.ConnectToDB()
+	}
+
+	token := os.Getenv("SBX_TOKEN")
+	if token == "" {
+		if session, err := helpers.LoadSession(); err == nil && session != nil && session.Remote == remote {
+			token = session.Token
+		}
+	}
+	return api.NewClient(remote, token), nil
+}
+
+// currentUser returns the user of the service token in SBX_TOKEN, or else of
+// the cached login session
+func currentUser(db dbpkg.Store) (dbpkg.User, error) {
+	if token := os.Getenv("SBX_TOKEN"); token != "" {
+		user, err := db.GetSessionUser(token)
+		if err != nil {
+			return dbpkg.User{}, fmt.Errorf("Authentication with SBX_TOKEN failed: %v", err)
+		}
+		return user, nil
+	}
+
+	session, err := helpers.LoadSession()
+	if err != nil {
+		return dbpkg.User{}, fmt.Errorf("Failed to load session: %v", err)
+	}
+	if session == nil {
+		return dbpkg.User{}, fmt.Errorf("You must log in with 'sbx login' before using this command.")
+	}
+	if remote := remoteURL(); session.Remote != remote {
+		return dbpkg.User{}, fmt.Errorf("You are logged in to %s, log in to %s with 'sbx login' before using this command.", backendName(session.Remote), backendName(remote))
+	}
+
+	user, err := db.GetSessionUser(session.Token)
+	if err != nil {
+		return dbpkg.User{}, fmt.Errorf("Authentication failed: %v", err)
+	}
+	return user, nil
+}
+
+// requireUser returns the current user, exiting if there is none
+func requireUser(db dbpkg.Store) dbpkg.User {
+	user, err := currentUser(db)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(errorStatus)
+	}
+	return user
+}
+
+// requireAdmin returns the logged in user, exiting unless they are an admin
+func requireAdmin(db dbpkg.Store) dbpkg.User {
+	user := requireUser(db)
+	if !user.Admin {
+		fmt.Fprintln(os.Stderr, "This command requires an admin user.")
+		os.Exit(errorStatus)
+	}
+	return user
+}
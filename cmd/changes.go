@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	dbpkg "github.com/spf13/sbx/db"
+	"github.com/spf13/sbx/helpers"
+)
+
+// localSecret is a key/value pair read from a local .env file
+type localSecret struct {
+	Key      string
+	Value    string
+	Location string
+}
+
+type changeKind int
+
+const (
+	changeAdded changeKind = iota
+	changeChanged
+	changeRemoved
+)
+
+// secretChange is a single difference between local and remote secrets
+type secretChange struct {
+	Kind     changeKind
+	Key      string
+	Location string
+	OldValue string
+	NewValue string
+}
+
+// diffSecrets compares local secrets against the secrets of an environment.
+// A secret is identified by its key and location, so the same key in two
+// directories is compared separately. Secrets whose local value matches an
+// inherited one are left alone. Remote secrets missing locally are reported as
+// removed only when prune is set, and only if they are defined in the
+// environment itself rather than inherited, unless environmentName is empty.
+func diffSecrets(local []localSecret, remote []dbpkg.Secret, environmentName string, prune bool) []secretChange {
+	remoteByRef := make(map[dbpkg.SecretRef]dbpkg.Secret)
+	for _, secret := range remote {
+		remoteByRef[dbpkg.SecretRef{Key: secret.Key, Location: secret.Location}] = secret
+	}
+
+	// Later definitions of a key in a location win, matching the order keys are written in
+	localByRef := make(map[dbpkg.SecretRef]localSecret)
+	var order []dbpkg.SecretRef
+	for _, secret := range local {
+		ref := dbpkg.SecretRef{Key: secret.Key, Location: secret.Location}
+		if _, seen := localByRef[ref]; !seen {
+			order = append(order, ref)
+		}
+		localByRef[ref] = secret
+	}
+
+	var changes []secretChange
+	for _, ref := range order {
+		secret := localByRef[ref]
+		existing, exists := remoteByRef[ref]
+		switch {
+		case !exists:
+			changes = append(changes, secretChange{Kind: changeAdded, Key: ref.Key, Location: ref.Location, NewValue: secret.Value})
+		case existing.Value != secret.Value:
+			changes = append(changes, secretChange{Kind: changeChanged, Key: ref.Key, Location: ref.Location, OldValue: existing.Value, NewValue: secret.Value})
+		}
+	}
+
+	if prune {
+		var removed []secretChange
+		for ref, secret := range remoteByRef {
+			inherited := environmentName != "" && secret.Source != environmentName
+			if _, exists := localByRef[ref]; !exists && !inherited {
+				removed = append(removed, secretChange{Kind: changeRemoved, Key: ref.Key, Location: ref.Location, OldValue: secret.Value})
+			}
+		}
+		sort.Slice(removed, func(i, j int) bool {
+			if removed[i].Key != removed[j].Key {
+				return removed[i].Key < removed[j].Key
+			}
+			return removed[i].Location < removed[j].Location
+		})
+		changes = append(changes, removed...)
+	}
+
+	return changes
+}
+
+// toChangeset turns computed changes into the changeset that writes them
+func toChangeset(changes []secretChange) dbpkg.Changeset {
+	var changeset dbpkg.Changeset
+	for _, change := range changes {
+		switch change.Kind {
+		case changeAdded, changeChanged:
+			changeset.Set = append(changeset.Set, dbpkg.SecretWrite{Key: change.Key, Value: change.NewValue, Location: change.Location})
+		case changeRemoved:
+			changeset.Delete = append(changeset.Delete, dbpkg.SecretRef{Key: change.Key, Location: change.Location})
+		}
+	}
+	return changeset
+}
+
+// secretLabel names a secret in messages, adding its location unless it is
+// stored at the root
+func secretLabel(key, location string) string {
+	if location == "." {
+		return key
+	}
+	return fmt.Sprintf("%s (%s)", key, location)
+}
+
+// printChanges prints a colored added/changed/removed summary, with values
+// shown through display, e.g. helpers.MaskValue
+func printChanges(changes []secretChange, display func(string) string) {
+	var added, changed, removed int
+	for _, change := range changes {
+		switch change.Kind {
+		case changeAdded:
+			added++
+			fmt.Println(helpers.Colorize(helpers.Green, fmt.Sprintf("+ %s = %s  (%s)", change.Key, display(change.NewValue), change.Location)))
+		case changeChanged:
+			changed++
+			fmt.Println(helpers.Colorize(helpers.Yellow, fmt.Sprintf("~ %s = %s -> %s  (%s)", change.Key, display(change.OldValue), display(change.NewValue), change.Location)))
+		case changeRemoved:
+			removed++
+			fmt.Println(helpers.Colorize(helpers.Red, fmt.Sprintf("- %s  (%s)", change.Key, change.Location)))
+		}
+	}
+	fmt.Printf("%d to add, %d to change, %d to remove\n", added, changed, removed)
+}
+
+// confirmChanges asks before writing to a protected environment. Without a
+// terminal to ask on, it refuses unless the caller already passed --yes.
+func confirmChanges(environment dbpkg.Environment, changes []secretChange, yes bool) bool {
+	if yes || !environment.Protected {
+		return true
+	}
+	if !helpers.IsInteractive() {
+		fmt.Fprintf(os.Stderr, "Refusing to modify %s without confirmation; re-run with --yes\n", environment.Name)
+		return false
+	}
+	return helpers.Confirm(fmt.Sprintf("Apply %d changes to %s?", len(changes), environment.Name))
+}
@@ -0,0 +1,201 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/spf13/sbx/config"
+	dbpkg "github.com/spf13/sbx/db"
+)
+
+// Completion of project, environment and key names, used by the shells set up
+// with 'sbx completion' as well as by 'sbx start'. Completions are best effort:
+// without a login or a connection they quietly offer nothing.
+
+// completionFunc completes the value of a flag or argument
+type completionFunc = func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective)
+
+// flagCompletions maps the names of string flags to how their values are completed
+var flagCompletions = map[string]completionFunc{
+	"project": completeProjects,
+	"env":     completeEnvironments,
+	"parent":  completeEnvironments,
+	"from":    completeEnvironments,
+	"to":      completeEnvironments,
+}
+
+// registerCompletions registers the completion of flagCompletions for every
+// command that defines one of those flags. It runs once all commands have been
+// added, so that flags don't need registering one by one.
+func registerCompletions(cmd *cobra.Command) {
+	for name, complete := range flagCompletions {
+		flag := cmd.LocalFlags().Lookup(name)
+		if flag == nil || !strings.HasPrefix(flag.Value.Type(), "string") {
+			continue
+		}
+		cmd.RegisterFlagCompletionFunc(name, complete)
+	}
+
+	for _, child := range cmd.Commands() {
+		registerCompletions(child)
+	}
+}
+
+// completionStore connects to the backend and returns the current user for
+// completing names, or false when either fails. Completions are read from
+// stdout, so anything printed while connecting is sent to stderr instead.
+func completionStore() (dbpkg.Store, dbpkg.User, bool) {
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+	defer func() { os.Stdout = stdout }()
+
+	db, err := connectStore()
+	if err != nil {
+		return nil, dbpkg.User{}, false
+	}
+	user, err := currentUser(db)
+	if err != nil {
+		db.Close()
+		return nil, dbpkg.User{}, false
+	}
+	return db, user, true
+}
+
+// completionProject returns the project a command line refers to, like
+// projectFromFlags but without printing or exiting
+func completionProject(cmd *cobra.Command) string {
+	if projectName, _ := cmd.Flags().GetString("project"); projectName != "" {
+		return projectName
+	}
+	cfg, err := config.Discover()
+	if err != nil {
+		return ""
+	}
+	if cfg.Project != "" {
+		return cfg.Project
+	}
+	return filepath.Base(cfg.Root)
+}
+
+// completionEnvironment returns the environment a command line refers to,
+// like environmentFromFlags but without printing or exiting. The environment
+// of promote is the one secrets are copied from.
+func completionEnvironment(cmd *cobra.Command) string {
+	if from, _ := cmd.Flags().GetString("from"); from != "" {
+		return from
+	}
+	if name, _ := cmd.Flags().GetString("env"); name != "" {
+		return name
+	}
+	for flag, environment := range map[string]string{"dev": "development", "staging": "staging", "prod": "production"} {
+		if selected, _ := cmd.Flags().GetBool(flag); selected {
+			return environment
+		}
+	}
+	if cfg, err := config.Discover(); err == nil {
+		return cfg.Environment
+	}
+	return ""
+}
+
+// matchingCompletions returns the candidates starting with toComplete
+func matchingCompletions(candidates []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	var matches []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, toComplete) {
+			matches = append(matches, candidate)
+		}
+	}
+	return matches, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeProjects completes the names of the projects the user can access
+func completeProjects(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	db, user, ok := completionStore()
+	if !ok {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	defer db.Close()
+
+	projects, err := db.ListProjects(user)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	var names []string
+	for _, project := range projects {
+		names = append(names, project.Name)
+	}
+	return matchingCompletions(names, toComplete)
+}
+
+// completeEnvironments completes the names of the environments of the project
+func completeEnvironments(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	projectName := completionProject(cmd)
+	if projectName == "" {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	db, user, ok := completionStore()
+	if !ok {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	defer db.Close()
+
+	environments, err := db.ListEnvironments(user, projectName)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	var names []string
+	for _, env := range environments {
+		if !slices.Contains(args, env.Name) {
+			names = append(names, env.Name)
+		}
+	}
+	return matchingCompletions(names, toComplete)
+}
+
+// completeKeys completes the keys of the secrets in the environment
+func completeKeys(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	projectName := completionProject(cmd)
+	environmentType := completionEnvironment(cmd)
+	if projectName == "" || environmentType == "" {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	db, user, ok := completionStore()
+	if !ok {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	defer db.Close()
+
+	keys, err := db.CompleteKeys(user, projectName, environmentType)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	var candidates []string
+	for _, key := range keys {
+		// A key stored in several locations is listed once per location
+		if !slices.Contains(args, key) && !slices.Contains(candidates, key) {
+			candidates = append(candidates, key)
+		}
+	}
+	return matchingCompletions(candidates, toComplete)
+}
+
+// completeArgs limits a completion of positional arguments to the first max
+// arguments
+func completeArgs(max int, complete completionFunc) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) >= max {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return complete(cmd, args, toComplete)
+	}
+}
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	dbpkg "github.com/spf13/sbx/db"
+)
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Compare secrets between local files, environments and projects",
+	Long: `The diff command compares two sets of secrets and shows the keys missing from
+the target (+), the extra keys only the target has (-) and the keys whose values
+differ (~).
+
+Without --from and --to, the local .env files are compared with an environment:
+
+  sbx diff --prod
+
+With --from and --to, two environments are compared. Each side is an
+environment of the current project, or PROJECT:ENVIRONMENT for another project:
+
+  sbx diff --from staging --to production
+  sbx diff --from api:production --to web:production
+
+Values are compared by their SHA-256 hash and never shown unless --show-values
+is given; --hash shows a short hash so equal values can be recognized. Like
+diff(1), the command exits with status 1 when there are differences, for drift
+checks in CI, and with status 2 when it can't compare, e.g. for lack of a login.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		errorStatus = 2
+
+		projectName, _ := cmd.Flags().GetString("project")
+		from, _ := cmd.Flags().GetString("from")
+		to, _ := cmd.Flags().GetString("to")
+		showValues, _ := cmd.Flags().GetBool("show-values")
+		showHash, _ := cmd.Flags().GetBool("hash")
+
+		if (from == "") != (to == "") {
+			fmt.Println("--from and --to must be given together")
+			os.Exit(errorStatus)
+		}
+
+		if projectName == "" {
+			projectName = defaultProject(os.Stdout)
+		}
+
+		// Local files are compared with the environment selected by the usual flags
+		var environmentType string
+		if from == "" {
+			environmentType = environmentFromFlags(cmd)
+		}
+
+		db, err := connectStore()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to connect to the database: %v\n", err)
+			os.Exit(errorStatus)
+		}
+		defer db.Close()
+
+		// Only authenticated users may proceed
+		user := requireUser(db)
+
+		var source []localSecret
+		var target []dbpkg.Secret
+		var targetEnvironment string
+		if from == "" {
+			source, err = readLocalEnvFiles()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to read local files: %v\n", err)
+				os.Exit(errorStatus)
+			}
+			target = fetchDiffSide(db, user, projectName, environmentType)
+			targetEnvironment = environmentType
+			fmt.Printf("Comparing local files with %s/%s\n", projectName, environmentType)
+		} else {
+			fromProject, fromEnvironment := parseDiffSide(from, projectName)
+			toProject, toEnvironment := parseDiffSide(to, projectName)
+
+			for _, secret := range fetchDiffSide(db, user, fromProject, fromEnvironment) {
+				source = append(source, localSecret{Key: secret.Key, Value: secret.Value, Location: secret.Location})
+			}
+			target = fetchDiffSide(db, user, toProject, toEnvironment)
+			fmt.Printf("Comparing %s/%s with %s/%s\n", fromProject, fromEnvironment, toProject, toEnvironment)
+		}
+
+		display := func(string) string { return "********" }
+		if showValues {
+			display = func(value string) string { return value }
+		} else {
+			// Only hashes are compared from here on
+			for i := range source {
+				source[i].Value = hashValue(source[i].Value)
+			}
+			for i := range target {
+				target[i].Value = hashValue(target[i].Value)
+			}
+			if showHash {
+				display = func(hash string) string { return "sha256:" + hash[:12] }
+			}
+		}
+
+		// Between environments every key of the target counts, inherited or not.
+		// Local files are compared like share does, ignoring inherited extras.
+		changes := diffSecrets(source, target, targetEnvironment, true)
+		if len(changes) == 0 {
+			fmt.Println("No differences")
+			return
+		}
+
+		printChanges(changes, display)
+		os.Exit(1)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+
+	// Flags for the diff command
+	diffCmd.Flags().StringP("project", "p", "", "Project name")
+	addEnvironmentFlags(diffCmd, "Compare local files with", "s")
+	diffCmd.Flags().String("from", "", "Environment to compare from, as ENVIRONMENT or PROJECT:ENVIRONMENT")
+	diffCmd.Flags().String("to", "", "Environment to compare to, as ENVIRONMENT or PROJECT:ENVIRONMENT")
+	diffCmd.Flags().Bool("show-values", false, "Show secret values instead of masking them")
+	diffCmd.Flags().Bool("hash", false, "Show a short SHA-256 hash of each value instead of masking it")
+}
+
+// parseDiffSide splits a [PROJECT:]ENVIRONMENT argument
+func parseDiffSide(side, defaultProject string) (string, string) {
+	if project, environment, found := strings.Cut(side, ":"); found {
+		return project, environment
+	}
+	return defaultProject, side
+}
+
+// fetchDiffSide returns the secrets of an environment, exiting if it can't
+func fetchDiffSide(db dbpkg.Store, user dbpkg.User, projectName, environmentType string) []dbpkg.Secret {
+	requireEnvironment(db, projectName, environmentType)
+
+	secrets, err := db.GetSecrets(user, projectName, environmentType)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to fetch secrets of %s/%s: %v\n", projectName, environmentType, err)
+		os.Exit(errorStatus)
+	}
+	return secrets
+}
+
+// hashValue returns the hex encoded SHA-256 of a value
+func hashValue(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
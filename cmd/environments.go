@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+
+	dbpkg "github.com/spf13/sbx/db"
+	"github.com/spf13/sbx/helpers"
+)
+
+// envCmd groups the environment management commands
+var envCmd = &cobra.Command{
+	Use:   "env",
+	Short: "Manage the environments of a project",
+	Long: `The env command manages the environments of a project. Every project starts
+with development, staging and production; any number of further environments,
+such as preview, qa or one per customer, can be added. Other commands select an
+environment with --env NAME.
+
+An environment can inherit from a parent environment: it sees all of the
+parent's secrets, and any key shared to it overrides the inherited value.`,
+}
+
+// envCreateCmd represents the env create command
+var envCreateCmd = &cobra.Command{
+	Use:   "create NAME",
+	Short: "Add an environment to a project",
+	Long: `Add an environment to a project. Changes to a --protected environment must be
+confirmed before they are written, as with staging and production. With
+--parent the environment inherits the secrets of another environment.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		projectName := projectFromFlags(cmd)
+		protected, _ := cmd.Flags().GetBool("protected")
+		parent, _ := cmd.Flags().GetString("parent")
+
+		db, err := connectStore()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to connect to the database: %v\n", err)
+			os.Exit(1)
+		}
+		defer db.Close()
+
+		// Only authenticated users may proceed
+		user := requireUser(db)
+
+		env := dbpkg.Environment{Name: name, Protected: protected, Parent: parent}
+		err = db.CreateEnvironment(user, projectName, env)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create environment: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Created the %s environment in '%s'\n", name, projectName)
+	},
+}
+
+// envParentCmd represents the env parent command
+var envParentCmd = &cobra.Command{
+	Use:   "parent NAME [PARENT]",
+	Short: "Set the environment an environment inherits from",
+	Long: `Make an environment inherit the secrets of PARENT. Keys defined in the
+environment itself keep overriding the inherited values. Use --clear to stop
+inheriting.`,
+	Args:              cobra.RangeArgs(1, 2),
+	ValidArgsFunction: completeArgs(2, completeEnvironments),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		projectName := projectFromFlags(cmd)
+		clearParent, _ := cmd.Flags().GetBool("clear")
+
+		var parent string
+		if len(args) == 2 {
+			parent = args[1]
+		}
+		if (parent == "" && !clearParent) || (parent != "" && clearParent) {
+			fmt.Println("You must specify either a parent environment or --clear")
+			os.Exit(1)
+		}
+
+		db, err := connectStore()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to connect to the database: %v\n", err)
+			os.Exit(1)
+		}
+		defer db.Close()
+
+		// Only authenticated users may proceed
+		user := requireUser(db)
+
+		err = db.SetEnvironmentParent(user, projectName, name, parent)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to set parent environment: %v\n", err)
+			os.Exit(1)
+		}
+		if parent == "" {
+			fmt.Printf("The %s environment no longer inherits secrets\n", name)
+		} else {
+			fmt.Printf("The %s environment now inherits from %s\n", name, parent)
+		}
+	},
+}
+
+// envListCmd represents the env list command
+var envListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the environments of a project",
+	Long:  `List the environments of a project and whether changes to them must be confirmed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		projectName := projectFromFlags(cmd)
+
+		db, err := connectStore()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to connect to the database: %v\n", err)
+			os.Exit(1)
+		}
+		defer db.Close()
+
+		// Only authenticated users may proceed
+		user := requireUser(db)
+
+		environments, err := db.ListEnvironments(user, projectName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to list environments: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Create a table to display the results
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Name", "Parent", "Protected"})
+
+		for _, env := range environments {
+			protected := "no"
+			if env.Protected {
+				protected = "yes"
+			}
+			parent := env.Parent
+			if parent == "" {
+				parent = "-"
+			}
+			table.Append([]string{env.Name, parent, protected})
+		}
+
+		// Render the table to stdout
+		table.Render()
+	},
+}
+
+// envDeleteCmd represents the env delete command
+var envDeleteCmd = &cobra.Command{
+	Use:   "delete NAME",
+	Short: "Delete an environment from a project",
+	Long: `Delete an environment from a project. An environment that still has secrets is
+only deleted with --force, which deletes its secrets along with it. Their
+history can't be shown or rolled back afterwards, not even by creating an
+environment of the same name, so export them first if they may be needed:
+
+  sbx grab --env NAME --format json --output NAME.json`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeArgs(1, completeEnvironments),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		projectName := projectFromFlags(cmd)
+		force, _ := cmd.Flags().GetBool("force")
+		yes, _ := cmd.Flags().GetBool("yes")
+
+		db, err := connectStore()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to connect to the database: %v\n", err)
+			os.Exit(1)
+		}
+		defer db.Close()
+
+		// Only authenticated users may proceed
+		user := requireUser(db)
+
+		environment := requireEnvironment(db, projectName, name)
+		if environment.Protected && !yes {
+			if !helpers.IsInteractive() {
+				fmt.Fprintf(os.Stderr, "Refusing to delete %s without confirmation; re-run with --yes\n", name)
+				os.Exit(1)
+			}
+			if !helpers.Confirm(fmt.Sprintf("Delete the protected %s environment of '%s'?", name, projectName)) {
+				fmt.Println("Aborted: the environment was not deleted")
+				os.Exit(1)
+			}
+		}
+
+		err = db.DeleteEnvironment(user, projectName, name, force)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to delete environment: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Deleted the %s environment from '%s'\n", name, projectName)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(envCmd)
+	envCmd.AddCommand(envCreateCmd)
+	envCmd.AddCommand(envParentCmd)
+	envCmd.AddCommand(envListCmd)
+	envCmd.AddCommand(envDeleteCmd)
+
+	// Flags shared by the env commands
+	envCmd.PersistentFlags().StringP("project", "p", "", "Project name")
+
+	// Flags for the env create command
+	envCreateCmd.Flags().Bool("protected", false, "Require confirmation before changes are written to the environment")
+	envCreateCmd.Flags().String("parent", "", "Environment to inherit secrets from")
+
+	// Flags for the env parent command
+	envParentCmd.Flags().Bool("clear", false, "Stop inheriting from the current parent")
+
+	// Flags for the env delete command
+	envDeleteCmd.Flags().Bool("force", false, "Delete the environment even if it still has secrets, whose history becomes unreachable")
+	envDeleteCmd.Flags().BoolP("yes", "y", false, "Delete a protected environment without asking for confirmation")
+}
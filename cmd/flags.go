@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/spf13/sbx/config"
+	dbpkg "github.com/spf13/sbx/db"
+)
+
+// projectConfig returns the .sbx.yaml configuration that applies to the
+// working directory, or the defaults without one, exiting if it can't be read
+func projectConfig() *config.Config {
+	cfg, err := config.Discover()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load project configuration: %v\n", err)
+		os.Exit(errorStatus)
+	}
+	return cfg
+}
+
+// projectRoot returns the directory secret locations are relative to, as a
+// path relative to the working directory when possible
+func projectRoot() string {
+	root := projectConfig().Root
+	if cwd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(cwd, root); err == nil {
+			return rel
+		}
+	}
+	return root
+}
+
+// defaultProject returns the project named in .sbx.yaml, falling back to the
+// name of the project root directory. Notes are printed to notes, so commands
+// that write data to stdout can keep it clean.
+func defaultProject(notes io.Writer) string {
+	cfg := projectConfig()
+	if cfg.Project != "" {
+		return cfg.Project
+	}
+
+	projectName := filepath.Base(cfg.Root)
+	fmt.Fprintf(notes, "Using directory name as project name: %s\n", projectName)
+	return projectName
+}
+
+// projectFromFlags returns the --project flag, falling back to the default project
+func projectFromFlags(cmd *cobra.Command) string {
+	projectName, _ := cmd.Flags().GetString("project")
+
+	if projectName == "" {
+		projectName = defaultProject(os.Stdout)
+	}
+
+	return projectName
+}
+
+// addEnvironmentFlags registers --env NAME along with the --dev, --staging and
+// --prod shortcuts for the default environments. usage completes the help text
+// of each flag, e.g. "Show secrets for".
+func addEnvironmentFlags(cmd *cobra.Command, usage, stagingShorthand string) {
+	cmd.Flags().StringP("env", "e", "", usage+" the named environment")
+	cmd.Flags().BoolP("dev", "d", false, usage+" the development environment")
+	cmd.Flags().BoolP("staging", stagingShorthand, false, usage+" the staging environment")
+	cmd.Flags().BoolP("prod", "r", false, usage+" the production environment")
+}
+
+// environmentFromFlags returns the environment selected with the flags
+// registered by addEnvironmentFlags. At most one of them may be given; without
+// one, the environment of .sbx.yaml is used.
+func environmentFromFlags(cmd *cobra.Command) string {
+	name, _ := cmd.Flags().GetString("env")
+	isDev, _ := cmd.Flags().GetBool("dev")
+	isStaging, _ := cmd.Flags().GetBool("staging")
+	isProd, _ := cmd.Flags().GetBool("prod")
+
+	var selected []string
+	if name != "" {
+		selected = append(selected, name)
+	}
+	if isDev {
+		selected = append(selected, "development")
+	}
+	if isStaging {
+		selected = append(selected, "staging")
+	}
+	if isProd {
+		selected = append(selected, "production")
+	}
+
+	if len(selected) == 0 {
+		if environment := projectConfig().Environment; environment != "" {
+			return environment
+		}
+	}
+
+	if len(selected) != 1 {
+		fmt.Println("You must specify one environment with --env NAME, or one of the following flags: --dev, --staging, or --prod")
+		os.Exit(errorStatus)
+	}
+	return selected[0]
+}
+
+// requireEnvironment exits unless the project has the named environment
+func requireEnvironment(db dbpkg.Store, projectName, environmentName string) dbpkg.Environment {
+	env, err := db.GetEnvironment(projectName, environmentName)
+	if errors.Is(err, dbpkg.ErrEnvironmentNotFound) {
+		fmt.Fprintf(os.Stderr, "Project '%s' has no '%s' environment. Create it with 'sbx env create %s'.\n", projectName, environmentName, environmentName)
+		os.Exit(errorStatus)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to fetch environment: %v\n", err)
+		os.Exit(errorStatus)
+	}
+	return env
+}
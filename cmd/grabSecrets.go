@@ -1,14 +1,17 @@
 package cmd
 
 import (
-	"database/sql"
+	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 
 	"github.com/spf13/cobra"
 
 	dbpkg "github.com/spf13/sbx/db"
+	"github.com/spf13/sbx/dotenv"
+	"github.com/spf13/sbx/format"
 	"github.com/spf13/sbx/helpers"
 )
 
@@ -17,47 +20,66 @@ var grabSecretsCmd = &cobra.Command{
 	Use:   "grab",
 	Short: "Retrieve secrets from the database and populate .env files",
 	Long: `The grab command retrieves secrets for a specified project and environment 
-from the database and updates or creates .env files in the appropriate locations.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		helpers.CheckIfStarted(started)
+from the database and updates or creates .env files in the appropriate locations.
+Each secret goes back to the directory it was shared from, relative to the
+project root where .sbx.yaml lives, so a monorepo gets its layout back,
+including keys such as PORT with a different value in api/.env and web/.env.
+
+With --format or --output, or a format other than dotenv set in .sbx.yaml, the
+secrets are exported in a single file instead, in any of the supported
+formats, or written to stdout with --output -:
+
+  sbx grab --prod --format k8s-secret | kubectl apply -f -
+  eval "$(sbx grab --dev --format shell)"
+
+With --merge existing .env files are updated in place instead of rewritten:
+keys from the database are added or updated, while local-only keys, comments
+and ordering are kept. When a local value differs from the database,
+--strategy decides which one wins: remote (default), local, or prompt to ask
+for each conflict.
 
+In CI, authenticate with a service token in SBX_TOKEN, see 'sbx token'.`,
+	Run: func(cmd *cobra.Command, args []string) {
 		projectName, _ := cmd.Flags().GetString("project")
-		isDev, _ := cmd.Flags().GetBool("dev")
-		isStaging, _ := cmd.Flags().GetBool("staging")
-		isProd, _ := cmd.Flags().GetBool("prod")
+		formatName := formatFromFlags(cmd)
+		output, _ := cmd.Flags().GetString("output")
+		location, _ := cmd.Flags().GetString("location")
+		// The table format a .sbx.yaml may set is only shown by 'sbx secrets'
+		if formatName == "table" && !cmd.Flags().Changed("format") {
+			formatName = "dotenv"
+		}
+		export := cmd.Flags().Changed("format") || formatName != "dotenv" || output != ""
+		merge, _ := cmd.Flags().GetBool("merge")
+		strategy, _ := cmd.Flags().GetString("strategy")
 
+		// Exported secrets may go to stdout, so notes are printed on stderr
 		if projectName == "" {
-			var err error
-			projectName, err = helpers.GetCurrentDirName()
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to determine project name: %v\n", err)
-				os.Exit(1)
-			}
-			fmt.Printf("Using current directory name as project name: %s\n", projectName)
-		}
-
-		var environmentType string
-		switch {
-		case isDev:
-			environmentType = "development"
-		case isStaging:
-			environmentType = "staging"
-		case isProd:
-			environmentType = "production"
-		default:
-			fmt.Println("You must specify one of the following flags: --dev, --staging, or --prod")
+			projectName = defaultProject(os.Stderr)
+		}
+
+		environmentType := environmentFromFlags(cmd)
+
+		if merge && export {
+			fmt.Println("--merge updates .env files and cannot be combined with --format or --output")
+			os.Exit(1)
+		}
+		if err := validateMergeStrategy(strategy); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
 			os.Exit(1)
 		}
 
-		dbConn, err := dbpkg.ConnectToDB()
+		dbConn, err := connectStore()
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to connect to the database: %v\n", err)
 			os.Exit(1)
 		}
 		defer dbConn.Close()
 
+		// Only authenticated users may proceed
+		user := requireUser(dbConn)
+
 		// first ProjectExists check to make sure that we can proceed
-		projectExists, err := dbpkg.ProjectExists(dbConn, projectName)
+		projectExists, err := dbConn.ProjectExists(projectName)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error checking if project exists: %v\n", err)
 			os.Exit(1)
@@ -66,8 +88,33 @@ from the database and updates or creates .env files in the appropriate locations
 			fmt.Fprintf(os.Stderr, "Project '%s' does not exist.\n", projectName)
 			os.Exit(1)
 		}
+		requireEnvironment(dbConn, projectName, environmentType)
 
-		err = processSecrets(dbConn, projectName, environmentType)
+		// Fetch all secrets for the given project and environment
+		secrets, err := dbConn.GetSecrets(user, projectName, environmentType)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to fetch secrets: %v\n", err)
+			os.Exit(1)
+		}
+
+		if export {
+			secrets, err = selectSecrets(secrets, location)
+			if err == nil {
+				meta := format.Meta{Project: projectName, Environment: environmentType}
+				err = writeFormatted(formatName, output, meta, secrets)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to export secrets: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		if merge {
+			err = mergeSecrets(projectRoot(), secrets, location, strategy)
+		} else {
+			err = processSecrets(projectRoot(), secrets, location)
+		}
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to process secrets: %v\n", err)
 			os.Exit(1)
@@ -80,60 +127,113 @@ func init() {
 
 	// Flags for the grab secrets command
 	grabSecretsCmd.Flags().StringP("project", "p", "", "Project name")
-	grabSecretsCmd.Flags().BoolP("dev", "d", false, "Grab secrets for the development environment")
-	grabSecretsCmd.Flags().BoolP("staging", "s", false, "Grab secrets for the staging environment")
-	grabSecretsCmd.Flags().BoolP("prod", "r", false, "Grab secrets for the production environment")
+	addEnvironmentFlags(grabSecretsCmd, "Grab secrets for", "s")
+	addOutputFlags(grabSecretsCmd, "dotenv")
+	grabSecretsCmd.Flags().Bool("merge", false, "Update existing .env files in place, keeping local-only keys and comments")
+	grabSecretsCmd.Flags().String("strategy", mergeRemote, "How --merge resolves conflicting values (remote, local, prompt)")
 }
 
-func processSecrets(db *sql.DB, projectName, environmentType string) error {
-	// Fetch all secrets for the given project and environment
-	secrets, err := dbpkg.GetSecrets(db, projectName, environmentType)
-	if err != nil {
-		return fmt.Errorf("error fetching secrets: %v", err)
-	}
+// processSecrets writes secrets to the .env file of their location below root,
+// limited to one location unless location is empty. Keys keep the order they have in an
+// existing file, so regenerating it only shows real changes in a diff; new
+// keys follow in alphabetical order.
+func processSecrets(root string, secrets []dbpkg.Secret, location string) error {
+	grouped := secretsByLocation(secrets, location)
 
-	// Group secrets by location
-	secretsByLocation := make(map[string]map[string]string)
-	for _, secret := range secrets {
-		if _, exists := secretsByLocation[secret.Location]; !exists {
-			secretsByLocation[secret.Location] = make(map[string]string)
+	// Process each location and create/update .env files
+	for _, location := range sortedLocations(grouped) {
+		fullPath, err := envFilePath(root, location)
+		if err != nil {
+			return err
 		}
-		secretsByLocation[secret.Location][secret.Key] = secret.Value
-	}
+		kvPairs := grouped[location]
 
-	// Process each location and create/update .env files
-	for location, kvPairs := range secretsByLocation {
-		// Resolve the full path
-		var fullPath string
-		if location == "." {
-			fullPath = "./.env"
-		} else {
-			fullPath = filepath.Join(location, ".env")
+		var entries []dotenv.Entry
+		for _, key := range orderedKeys(fullPath, kvPairs) {
+			entries = append(entries, dotenv.Entry{Key: key, Value: kvPairs[key]})
+		}
+
+		var buf bytes.Buffer
+		if err := dotenv.Write(&buf, entries); err != nil {
+			return fmt.Errorf("error formatting %s: %v", fullPath, err)
 		}
 
 		// Create the directory if it doesn't exist
-		err := os.MkdirAll(filepath.Dir(fullPath), os.ModePerm)
+		err = os.MkdirAll(filepath.Dir(fullPath), os.ModePerm)
 		if err != nil {
 			return fmt.Errorf("error creating directory %s: %v", filepath.Dir(fullPath), err)
 		}
 
-		// Open or create the .env file
-		file, err := os.OpenFile(fullPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
-		if err != nil {
-			return fmt.Errorf("error opening file %s: %v", fullPath, err)
+		// Replace the file in one step so a crash never leaves it truncated
+		if err := helpers.WriteFileAtomic(fullPath, buf.Bytes(), 0600); err != nil {
+			return fmt.Errorf("error writing to file %s: %v", fullPath, err)
 		}
-		defer file.Close()
 
-		// Write the key/value pairs to the file
-		for key, value := range kvPairs {
-			_, err := file.WriteString(fmt.Sprintf("%s=%s\n", key, value))
-			if err != nil {
-				return fmt.Errorf("error writing to file %s: %v", fullPath, err)
+		fmt.Printf("Updated file: %s\n", fullPath)
+	}
+
+	return nil
+}
+
+// orderedKeys returns the keys in the order they appear in the existing file
+// at path, followed by the remaining keys sorted
+func orderedKeys(path string, kvPairs map[string]string) []string {
+	var keys []string
+	seen := make(map[string]bool)
+
+	// A missing or unreadable file just means there is no order to keep
+	if existing, err := dotenv.ParseFile(path); err == nil {
+		for _, entry := range existing {
+			if _, wanted := kvPairs[entry.Key]; wanted && !seen[entry.Key] {
+				keys = append(keys, entry.Key)
+				seen[entry.Key] = true
 			}
 		}
+	}
 
-		fmt.Printf("Updated file: %s\n", fullPath)
+	var rest []string
+	for key := range kvPairs {
+		if !seen[key] {
+			rest = append(rest, key)
+		}
 	}
+	sort.Strings(rest)
+	return append(keys, rest...)
+}
 
-	return nil
+// sortedLocations returns the locations of grouped secrets in alphabetical order
+func sortedLocations(grouped map[string]map[string]string) []string {
+	var locations []string
+	for location := range grouped {
+		locations = append(locations, location)
+	}
+	sort.Strings(locations)
+	return locations
+}
+
+// secretsByLocation groups secrets by location, limited to one location
+// unless location is empty
+func secretsByLocation(secrets []dbpkg.Secret, location string) map[string]map[string]string {
+	grouped := make(map[string]map[string]string)
+	for _, secret := range secrets {
+		if location != "" && secret.Location != location {
+			continue
+		}
+		if _, exists := grouped[secret.Location]; !exists {
+			grouped[secret.Location] = make(map[string]string)
+		}
+		grouped[secret.Location][secret.Key] = secret.Value
+	}
+	return grouped
+}
+
+// envFilePath returns the path of the .env file for a secret location below
+// root. Locations come from the database, so one that would lead out of root
+// is refused rather than trusted.
+func envFilePath(root, location string) (string, error) {
+	dir := filepath.Join(root, filepath.FromSlash(location))
+	if rel, err := filepath.Rel(root, dir); err != nil || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("refusing to write secrets of location '%s' outside of the project", location)
+	}
+	return filepath.Join(dir, ".env"), nil
 }
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+
+	"github.com/spf13/sbx/helpers"
+)
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history KEY",
+	Short: "Show the version history of a secret",
+	Long: `The history command lists every version of a secret in an environment,
+including deletions, along with who made each change and when.
+Values are masked unless --show-values is given. When the key is stored in
+several locations, --location selects which one.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeArgs(1, completeKeys),
+	Run: func(cmd *cobra.Command, args []string) {
+		key := args[0]
+		projectName, _ := cmd.Flags().GetString("project")
+		location, _ := cmd.Flags().GetString("location")
+		showValues, _ := cmd.Flags().GetBool("show-values")
+
+		if projectName == "" {
+			projectName = defaultProject(os.Stdout)
+		}
+
+		environmentType := environmentFromFlags(cmd)
+
+		db, err := connectStore()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to connect to the database: %v\n", err)
+			os.Exit(1)
+		}
+		defer db.Close()
+
+		// Only authenticated users may proceed
+		user := requireUser(db)
+
+		versions, err := db.SecretHistory(user, key, location, projectName, environmentType)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to fetch history: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Create a table to display the results
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Version", "Value", "Location", "Changed By", "Changed At", "Note"})
+
+		for _, version := range versions {
+			value := version.Value
+			if version.Deleted {
+				value = "(deleted)"
+			} else if !showValues {
+				value = helpers.MaskValue(value)
+			}
+
+			changedBy := version.CreatedBy.Email
+			if changedBy == "" {
+				changedBy = "-"
+			}
+
+			table.Append([]string{
+				strconv.Itoa(version.Version),
+				value,
+				version.Location,
+				changedBy,
+				version.CreatedAt.Local().Format("2006-01-02 15:04:05"),
+				version.Note,
+			})
+		}
+
+		// Render the table to stdout
+		table.Render()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+
+	// Flags for the history command
+	historyCmd.Flags().StringP("project", "p", "", "Project name")
+	addEnvironmentFlags(historyCmd, "Show history in", "s")
+	historyCmd.Flags().StringP("location", "l", "", "Location of the secret when the key is stored in several (e.g. '.' or 'api')")
+	historyCmd.Flags().Bool("show-values", false, "Show secret values instead of masking them")
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/spf13/sbx/config"
+	dbpkg "github.com/spf13/sbx/db"
+	"github.com/spf13/sbx/format"
+)
+
+// initCmd represents the init command
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Create a .sbx.yaml project configuration",
+	Long: `The init command writes a .sbx.yaml file to the current directory. Commands
+run anywhere below it, up to the repository root, use the project it names
+instead of guessing the project from the directory name, and store secret
+locations relative to it.
+
+  project       project name used when --project is not given
+  environment   environment used when no environment flag is given
+  include       directories searched for secret files (default: the root)
+  exclude       paths or names skipped while searching, e.g. node_modules
+  files         patterns of secret file names (default: *.env)
+  format        output format of grab and secrets
+
+Patterns follow shell globbing. Exclude patterns without a slash match any
+file or directory of that name; those with a slash match paths from the root.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		projectName, _ := cmd.Flags().GetString("project")
+		environment, _ := cmd.Flags().GetString("env")
+		formatName, _ := cmd.Flags().GetString("format")
+		exclude, _ := cmd.Flags().GetStringSlice("exclude")
+		force, _ := cmd.Flags().GetBool("force")
+
+		dir, err := os.Getwd()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to get the current directory: %v\n", err)
+			os.Exit(1)
+		}
+
+		if projectName == "" {
+			projectName = filepath.Base(dir)
+			fmt.Printf("Using directory name as project name: %s\n", projectName)
+		}
+		if environment != "" {
+			if err := dbpkg.ValidateEnvironmentName(environment); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+		}
+		if formatName != "" && formatName != "table" {
+			if _, err := format.Get(formatName); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		cfg := config.Config{
+			Project:     projectName,
+			Environment: environment,
+			Exclude:     exclude,
+			Files:       config.DefaultFiles,
+			Format:      formatName,
+		}
+
+		path := filepath.Join(dir, config.FileName)
+		err = cfg.Write(path, force)
+		if errors.Is(err, fs.ErrExist) {
+			fmt.Printf("%s already exists, use --force to replace it\n", path)
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write %s: %v\n", path, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Created %s for project '%s'\n", path, projectName)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(initCmd)
+
+	// Flags for the init command
+	initCmd.Flags().StringP("project", "p", "", "Project name (defaults to the directory name)")
+	initCmd.Flags().StringP("env", "e", "", "Environment to use when no environment flag is given")
+	initCmd.Flags().StringP("format", "f", "", "Output format of grab and secrets")
+	initCmd.Flags().StringSlice("exclude", []string{".git", "node_modules", "vendor"}, "Paths or names to skip when looking for secret files")
+	initCmd.Flags().Bool("force", false, "Replace an existing .sbx.yaml")
+}
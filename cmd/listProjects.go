@@ -6,56 +6,41 @@ import (
 
 	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
-
-	dbpkg "github.com/spf13/sbx/db"
-	"github.com/spf13/sbx/helpers"
 )
 
 // listProjectsCmd represents the list projects command
 var listProjectsCmd = &cobra.Command{
 	Use:   "projects",
 	Short: "List all projects",
-	Long:  `List all projects in the system, displaying their names and active status.`,
+	Long:  `List the projects you are a member of (all projects for admins), displaying their names and active status.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		helpers.CheckIfStarted(started)
-
-		db, err := dbpkg.ConnectToDB()
+		db, err := connectStore()
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to connect to the database: %v\n", err)
 			os.Exit(1)
 		}
 		defer db.Close()
 
-		rows, err := db.Query("SELECT name, active FROM projects")
+		// Only authenticated users may proceed
+		user := requireUser(db)
+
+		projects, err := db.ListProjects(user)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to execute query: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Failed to fetch projects: %v\n", err)
 			return
 		}
-		defer rows.Close()
 
 		// Create a table to display the results
 		table := tablewriter.NewWriter(os.Stdout)
 		table.SetHeader([]string{"Project Name", "Active"})
 
-		for rows.Next() {
-			var name string
-			var active bool
-			if err := rows.Scan(&name, &active); err != nil {
-				fmt.Fprintf(os.Stderr, "Error scanning row: %v\n", err)
-				return
-			}
-
+		for _, project := range projects {
 			activeStr := "No"
-			if active {
+			if project.Active {
 				activeStr = "Yes"
 			}
 
-			table.Append([]string{name, activeStr})
-		}
-
-		if err := rows.Err(); err != nil {
-			fmt.Fprintf(os.Stderr, "Error during rows iteration: %v\n", err)
-			return
+			table.Append([]string{project.Name, activeStr})
 		}
 
 		// Render the table to stdout
@@ -6,9 +6,6 @@ import (
 
 	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
-
-	dbpkg "github.com/spf13/sbx/db"
-	"github.com/spf13/sbx/helpers"
 )
 
 // listUsersCmd represents the list users command
@@ -17,45 +14,33 @@ var listUsersCmd = &cobra.Command{
 	Short: "List all users",
 	Long:  `List all users in the system, displaying their email addresses and admin status.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		helpers.CheckIfStarted(started)
-
-		db, err := dbpkg.ConnectToDB()
+		db, err := connectStore()
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to connect to the database: %v\n", err)
 			os.Exit(1)
 		}
 		defer db.Close()
 
-		rows, err := db.Query("SELECT email, admin FROM users")
+		// Only authenticated users may proceed
+		user := requireUser(db)
+
+		users, err := db.ListUsers(user)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to execute query: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Failed to fetch users: %v\n", err)
 			return
 		}
-		defer rows.Close()
 
 		// Create a table to display the results
 		table := tablewriter.NewWriter(os.Stdout)
 		table.SetHeader([]string{"Email", "Admin"})
 
-		for rows.Next() {
-			var email string
-			var admin bool
-			if err := rows.Scan(&email, &admin); err != nil {
-				fmt.Fprintf(os.Stderr, "Error scanning row: %v\n", err)
-				return
-			}
-
+		for _, user := range users {
 			adminStr := "No"
-			if admin {
+			if user.Admin {
 				adminStr = "Yes"
 			}
 
-			table.Append([]string{email, adminStr})
-		}
-
-		if err := rows.Err(); err != nil {
-			fmt.Fprintf(os.Stderr, "Error during rows iteration: %v\n", err)
-			return
+			table.Append([]string{user.Email, adminStr})
 		}
 
 		// Render the table to stdout
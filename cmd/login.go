@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/spf13/sbx/helpers"
+)
+
+// loginCmd represents the login command
+var loginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Log in and cache a session for subsequent commands",
+	Long: `The login command verifies your email and password and caches a short-lived
+session token in ~/.sbx so the other commands can identify you. With --remote
+the session is created on that sbx server and only sent to it.
+If no password is given on the command line you will be prompted for it.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		email, _ := cmd.Flags().GetString("email")
+		password, _ := cmd.Flags().GetString("password")
+
+		if email == "" {
+			fmt.Println("Email is required")
+			os.Exit(1)
+		}
+
+		if password == "" {
+			var err error
+			password, err = helpers.PromptPassword("Password: ")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		db, err := connectStore()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to connect to the database: %v\n", err)
+			os.Exit(1)
+		}
+		defer db.Close()
+
+		user, token, expiresAt, err := db.Login(email, password)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Login failed: %v\n", err)
+			os.Exit(1)
+		}
+
+		err = helpers.SaveSession(helpers.Session{Email: user.Email, Token: token, ExpiresAt: expiresAt, Remote: remoteURL()})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to save session: %v\n", err)
+			os.Exit(1)
+		}
+
+		if remote := remoteURL(); remote != "" {
+			fmt.Printf("Logged in to %s as %s (session expires %s)\n", remote, user.Email, expiresAt.Local().Format("2006-01-02 15:04"))
+		} else {
+			fmt.Printf("Logged in as %s (session expires %s)\n", user.Email, expiresAt.Local().Format("2006-01-02 15:04"))
+		}
+	},
+}
+
+// logoutCmd represents the logout command
+var logoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "End the cached login session",
+	Long:  `The logout command revokes the cached session token and removes it from ~/.sbx.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		session, err := helpers.LoadSession()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load session: %v\n", err)
+			os.Exit(1)
+		}
+
+		if session != nil {
+			// Revoke the session on the server that issued it
+			db, err := connectTo(session.Remote)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to connect to the database: %v\n", err)
+				os.Exit(1)
+			}
+			defer db.Close()
+
+			if err := db.DeleteSession(session.Token); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to revoke session: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		if err := helpers.ClearSession(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("Logged out")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(loginCmd)
+	rootCmd.AddCommand(logoutCmd)
+
+	// Flags for the login command
+	loginCmd.Flags().StringP("email", "e", "", "Email address of the user")
+	loginCmd.Flags().StringP("password", "p", "", "Password for the user (prompted for if omitted)")
+}
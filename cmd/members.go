@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+
+	dbpkg "github.com/spf13/sbx/db"
+)
+
+// membersCmd groups the project membership commands
+var membersCmd = &cobra.Command{
+	Use:   "members",
+	Short: "Manage who can access a project",
+	Long: `The members command manages project memberships. Each member has a role:
+
+  viewer      can read secrets
+  developer   can read and write secrets
+  maintainer  developer, plus project maintenance such as key rotation
+  admin       maintainer, plus managing members
+
+Members can additionally be restricted to specific environments with --env.`,
+}
+
+// membersAddCmd represents the members add command
+var membersAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Add a member to a project or change their role",
+	Long: `Add a user to a project with the given role. Running add for an existing member
+replaces their role and environment restrictions. Without --env the member may
+access every environment of the project.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		projectName := projectFromFlags(cmd)
+		email, _ := cmd.Flags().GetString("email")
+		roleName, _ := cmd.Flags().GetString("role")
+		environments, _ := cmd.Flags().GetStringSlice("env")
+
+		if email == "" {
+			fmt.Println("Email is required")
+			os.Exit(1)
+		}
+
+		role, err := dbpkg.ParseRole(roleName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+
+		db, err := connectStore()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to connect to the database: %v\n", err)
+			os.Exit(1)
+		}
+		defer db.Close()
+
+		// Only authenticated users may proceed
+		user := requireUser(db)
+
+		err = db.AddMember(user, projectName, email, role, environments)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to add member: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s is now a %s of '%s'\n", email, role, projectName)
+	},
+}
+
+// membersRemoveCmd represents the members remove command
+var membersRemoveCmd = &cobra.Command{
+	Use:   "remove",
+	Short: "Remove a member from a project",
+	Long:  `Remove a user's membership, revoking their access to the project.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		projectName := projectFromFlags(cmd)
+		email, _ := cmd.Flags().GetString("email")
+
+		if email == "" {
+			fmt.Println("Email is required")
+			os.Exit(1)
+		}
+
+		db, err := connectStore()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to connect to the database: %v\n", err)
+			os.Exit(1)
+		}
+		defer db.Close()
+
+		// Only authenticated users may proceed
+		user := requireUser(db)
+
+		err = db.RemoveMember(user, projectName, email)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to remove member: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Removed %s from '%s'\n", email, projectName)
+	},
+}
+
+// membersListCmd represents the members list command
+var membersListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the members of a project",
+	Long:  `List the members of a project along with their roles and environment restrictions.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		projectName := projectFromFlags(cmd)
+
+		db, err := connectStore()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to connect to the database: %v\n", err)
+			os.Exit(1)
+		}
+		defer db.Close()
+
+		// Only authenticated users may proceed
+		user := requireUser(db)
+
+		members, err := db.ListMembers(user, projectName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to list members: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Create a table to display the results
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Email", "Role", "Environments"})
+
+		for _, member := range members {
+			environments := "all"
+			if len(member.Environments) > 0 {
+				environments = strings.Join(member.Environments, ", ")
+			}
+			table.Append([]string{member.User.Email, string(member.Role), environments})
+		}
+
+		// Render the table to stdout
+		table.Render()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(membersCmd)
+	membersCmd.AddCommand(membersAddCmd)
+	membersCmd.AddCommand(membersRemoveCmd)
+	membersCmd.AddCommand(membersListCmd)
+
+	// Flags shared by the members commands
+	membersCmd.PersistentFlags().StringP("project", "p", "", "Project name")
+
+	// Flags for the members add command
+	membersAddCmd.Flags().StringP("email", "e", "", "Email address of the user")
+	membersAddCmd.Flags().StringP("role", "r", string(dbpkg.RoleDeveloper), "Role of the member (viewer, developer, maintainer, admin)")
+	membersAddCmd.Flags().StringSlice("env", nil, "Restrict the member to these environments (repeatable, default all)")
+
+	// Flags for the members remove command
+	membersRemoveCmd.Flags().StringP("email", "e", "", "Email address of the user")
+}
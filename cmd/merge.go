@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	dbpkg "github.com/spf13/sbx/db"
+	"github.com/spf13/sbx/dotenv"
+	"github.com/spf13/sbx/helpers"
+)
+
+// Strategies for resolving keys whose local value differs from the database
+const (
+	mergeRemote = "remote"
+	mergeLocal  = "local"
+	mergePrompt = "prompt"
+)
+
+// validateMergeStrategy checks a --strategy value
+func validateMergeStrategy(strategy string) error {
+	switch strategy {
+	case mergeRemote, mergeLocal:
+		return nil
+	case mergePrompt:
+		if !helpers.IsInteractive() {
+			return fmt.Errorf("--strategy prompt needs a terminal to ask on")
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid strategy '%s' (valid strategies: remote, local, prompt)", strategy)
+	}
+}
+
+// mergeSecrets updates the .env file of each location below root in place: keys from the
+// database are added or updated, everything else in the file is kept as is
+func mergeSecrets(root string, secrets []dbpkg.Secret, location, strategy string) error {
+	grouped := secretsByLocation(secrets, location)
+
+	for _, location := range sortedLocations(grouped) {
+		fullPath, err := envFilePath(root, location)
+		if err != nil {
+			return err
+		}
+
+		doc := &dotenv.Document{}
+		original, err := os.ReadFile(fullPath)
+		switch {
+		case err == nil:
+			doc, err = dotenv.ParseDocument(bytes.NewReader(original))
+			if err != nil {
+				return fmt.Errorf("error parsing %s: %v", fullPath, err)
+			}
+		case !os.IsNotExist(err):
+			return fmt.Errorf("error reading %s: %v", fullPath, err)
+		}
+
+		added, updated, kept := mergeDocument(doc, grouped[location], fullPath, strategy)
+		if bytes.Equal(doc.Bytes(), original) {
+			fmt.Printf("Unchanged file: %s\n", fullPath)
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(fullPath), os.ModePerm); err != nil {
+			return fmt.Errorf("error creating directory %s: %v", filepath.Dir(fullPath), err)
+		}
+		if err := helpers.WriteFileAtomic(fullPath, doc.Bytes(), 0600); err != nil {
+			return fmt.Errorf("error writing to file %s: %v", fullPath, err)
+		}
+		fmt.Printf("Merged file: %s (%d added, %d updated, %d local values kept)\n", fullPath, added, updated, kept)
+	}
+
+	return nil
+}
+
+// mergeDocument applies remote values to a document, resolving conflicts with
+// the strategy. It returns how many keys were added, updated and kept local.
+func mergeDocument(doc *dotenv.Document, remote map[string]string, path, strategy string) (added, updated, kept int) {
+	var keys []string
+	for key := range remote {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := remote[key]
+		local, exists := doc.Get(key)
+		switch {
+		case !exists:
+			doc.Set(key, value)
+			added++
+		case local.Value == value:
+			// Already up to date
+		case resolveConflict(path, key, local.Value, value, strategy) == mergeRemote:
+			doc.Set(key, value)
+			updated++
+		default:
+			kept++
+		}
+	}
+	return added, updated, kept
+}
+
+// resolveConflict reports a key whose local value differs from the database
+// and returns which side wins
+func resolveConflict(path, key, localValue, remoteValue, strategy string) string {
+	fmt.Println(helpers.Colorize(helpers.Yellow, fmt.Sprintf("Conflict in %s: %s is %s locally and %s in the database",
+		path, key, helpers.MaskValue(localValue), helpers.MaskValue(remoteValue))))
+
+	if strategy != mergePrompt {
+		return strategy
+	}
+	for {
+		// Keeping the local value is the default, as it is the non-destructive choice
+		switch helpers.Ask(fmt.Sprintf("Keep the local or the remote value of %s? [L/r]", key)) {
+		case "", "l", "local":
+			return mergeLocal
+		case "r", "remote":
+			return mergeRemote
+		}
+	}
+}
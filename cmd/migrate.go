@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+
+	dbpkg "github.com/spf13/sbx/db"
+)
+
+// dbCmd groups the database maintenance commands
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Manage the SecretBase database schema",
+	Long: `The db command groups maintenance tasks for the database backing SecretBase,
+such as creating the schema and applying migrations.`,
+}
+
+// dbMigrateCmd represents the db migrate command
+var dbMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Create or upgrade the database schema",
+	Long: `The migrate command applies every pending schema migration shipped with sbx.
+Running it against a blank database creates all of the tables SecretBase needs.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		db, err := dbpkg.OpenDB()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to connect to the database: %v\n", err)
+			os.Exit(1)
+		}
+		defer db.Close()
+
+		applied, err := db.Migrate()
+		for _, migration := range applied {
+			fmt.Printf("Applied migration %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to migrate database: %v\n", err)
+			os.Exit(1)
+		}
+
+		if len(applied) == 0 {
+			fmt.Println("Database schema is already up to date")
+		} else {
+			fmt.Println("Database schema is up to date")
+		}
+	},
+}
+
+// dbStatusCmd represents the db status command
+var dbStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show which schema migrations have been applied",
+	Long:  `List every schema migration shipped with sbx and when it was applied to the database.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		db, err := dbpkg.OpenDB()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to connect to the database: %v\n", err)
+			os.Exit(1)
+		}
+		defer db.Close()
+
+		migrations, err := db.MigrationStatus()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to fetch migration status: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Create a table to display the results
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Version", "Name", "Applied At"})
+
+		for _, migration := range migrations {
+			appliedStr := "Pending"
+			if migration.AppliedAt != nil {
+				appliedStr = migration.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			table.Append([]string{fmt.Sprintf("%04d", migration.Version), migration.Name, appliedStr})
+		}
+
+		// Render the table to stdout
+		table.Render()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(dbCmd)
+	dbCmd.AddCommand(dbMigrateCmd)
+	dbCmd.AddCommand(dbStatusCmd)
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	dbpkg "github.com/spf13/sbx/db"
+	"github.com/spf13/sbx/format"
+	"github.com/spf13/sbx/helpers"
+)
+
+// addOutputFlags registers the --format, --output and --location flags used
+// to export secrets
+func addOutputFlags(cmd *cobra.Command, defaultFormat string) {
+	formats := format.Names()
+	if defaultFormat == "table" {
+		formats = append([]string{"table"}, formats...)
+	}
+	cmd.Flags().StringP("format", "f", defaultFormat, fmt.Sprintf("Output format (%s)", strings.Join(formats, ", ")))
+	cmd.Flags().StringP("output", "o", "", "Write the output to this file, or '-' for stdout")
+	cmd.Flags().StringP("location", "l", "", "Only use secrets stored for this location (e.g. '.' or 'api')")
+}
+
+// formatFromFlags returns the --format flag, or the format of .sbx.yaml when
+// the flag is not given
+func formatFromFlags(cmd *cobra.Command) string {
+	formatName, _ := cmd.Flags().GetString("format")
+	if !cmd.Flags().Changed("format") {
+		if configured := projectConfig().Format; configured != "" {
+			return configured
+		}
+	}
+	return formatName
+}
+
+// selectSecrets keeps the secrets of one location, or of every location when
+// location is empty. A key stored with different values in several locations
+// is ambiguous without a location.
+func selectSecrets(secrets []dbpkg.Secret, location string) ([]dbpkg.Secret, error) {
+	var selected []dbpkg.Secret
+	seen := make(map[string]dbpkg.Secret)
+	for _, secret := range secrets {
+		if location != "" && secret.Location != location {
+			continue
+		}
+		if existing, ok := seen[secret.Key]; ok {
+			if existing.Value != secret.Value {
+				return nil, fmt.Errorf("%s has different values in %s and %s, select one with --location", secret.Key, existing.Location, secret.Location)
+			}
+			continue
+		}
+		seen[secret.Key] = secret
+		selected = append(selected, secret)
+	}
+	return selected, nil
+}
+
+// writeFormatted renders secrets in the named format to a file, or to stdout
+// when output is empty or "-". The output is rendered completely before the
+// file is touched, so a failure never leaves a partial file behind.
+func writeFormatted(formatName, output string, meta format.Meta, secrets []dbpkg.Secret) error {
+	formatter, err := format.Get(formatName)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := formatter.Format(&buf, meta, secrets); err != nil {
+		return fmt.Errorf("error formatting secrets as %s: %v", formatName, err)
+	}
+
+	if output == "" || output == "-" {
+		_, err = os.Stdout.Write(buf.Bytes())
+		return err
+	}
+
+	if err := helpers.WriteFileAtomic(output, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("error writing %s: %v", output, err)
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %d secrets to %s\n", len(secrets), output)
+	return nil
+}
@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	dbpkg "github.com/spf13/sbx/db"
+	"github.com/spf13/sbx/helpers"
+)
+
+// promoteCmd represents the promote command
+var promoteCmd = &cobra.Command{
+	Use:   "promote --from ENV --to ENV [KEY...]",
+	Short: "Copy secrets from one environment to another",
+	Long: `The promote command copies the given keys, or every key, from one environment
+of a project to another, e.g. once a configuration has been validated in staging:
+
+  sbx promote --from staging --to production
+  sbx promote --from staging --to production API_KEY FEATURE_FLAGS
+  sbx promote --from staging --to production --exclude DATABASE_URL,REDIS_URL
+
+Keys that only exist in the target are left alone. The changes are shown with
+values masked before anything is written, and applied in a single transaction.
+Every promoted version is marked in the history with the environment it came
+from. Promotions to a protected environment must be confirmed unless --yes is
+given.`,
+	ValidArgsFunction: completeKeys,
+	Run: func(cmd *cobra.Command, args []string) {
+		projectName, _ := cmd.Flags().GetString("project")
+		from, _ := cmd.Flags().GetString("from")
+		to, _ := cmd.Flags().GetString("to")
+		exclude, _ := cmd.Flags().GetStringSlice("exclude")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		yes, _ := cmd.Flags().GetBool("yes")
+
+		if from == "" || to == "" {
+			fmt.Println("You must specify both --from and --to")
+			os.Exit(1)
+		}
+		if from == to {
+			fmt.Println("--from and --to must be different environments")
+			os.Exit(1)
+		}
+
+		if projectName == "" {
+			projectName = defaultProject(os.Stdout)
+		}
+
+		db, err := connectStore()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to connect to the database: %v\n", err)
+			os.Exit(1)
+		}
+		defer db.Close()
+
+		// Only authenticated users may proceed
+		user := requireUser(db)
+
+		requireEnvironment(db, projectName, from)
+		target := requireEnvironment(db, projectName, to)
+
+		source, err := db.GetSecrets(user, projectName, from)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to fetch secrets of %s: %v\n", from, err)
+			os.Exit(1)
+		}
+		existing, err := db.GetSecrets(user, projectName, to)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to fetch secrets of %s: %v\n", to, err)
+			os.Exit(1)
+		}
+
+		selected, err := selectPromoted(source, args, exclude)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v in %s\n", err, from)
+			os.Exit(1)
+		}
+
+		changes := diffSecrets(selected, existing, to, false)
+		if len(changes) == 0 {
+			fmt.Printf("The %s environment is already up to date with %s\n", to, from)
+			return
+		}
+
+		printChanges(changes, helpers.MaskValue)
+		if dryRun {
+			fmt.Println("Dry run: no changes were written")
+			return
+		}
+		if !confirmChanges(target, changes, yes) {
+			fmt.Println("Aborted: no changes were written")
+			os.Exit(1)
+		}
+
+		changeset := toChangeset(changes)
+		changeset.Note = "promoted from " + from
+		changeset.Action = dbpkg.AuditPromote
+		err = db.ApplyChangeset(user, projectName, to, changeset)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to promote secrets, nothing was written: %v\n", err)
+			os.Exit(1)
+		}
+
+		for _, change := range changes {
+			fmt.Printf("Promoted secret: %s\n", secretLabel(change.Key, change.Location))
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(promoteCmd)
+
+	// Flags for the promote command
+	promoteCmd.Flags().StringP("project", "p", "", "Project name")
+	promoteCmd.Flags().String("from", "", "Environment to copy secrets from")
+	promoteCmd.Flags().String("to", "", "Environment to copy secrets to")
+	promoteCmd.Flags().StringSliceP("exclude", "x", nil, "Keys to leave out, such as environment specific URLs (repeatable)")
+	promoteCmd.RegisterFlagCompletionFunc("exclude", completeKeys)
+	promoteCmd.Flags().Bool("dry-run", false, "Show what would change without writing anything")
+	promoteCmd.Flags().BoolP("yes", "y", false, "Promote to a protected environment without asking for confirmation")
+}
+
+// selectPromoted picks the secrets to promote: the given keys, in every
+// location they are stored in, or every secret when no keys are given, minus
+// the excluded keys
+func selectPromoted(source []dbpkg.Secret, keys, exclude []string) ([]localSecret, error) {
+	wanted := make(map[string]bool)
+	for _, key := range keys {
+		wanted[key] = true
+	}
+	excluded := make(map[string]bool)
+	for _, key := range exclude {
+		excluded[key] = true
+	}
+
+	var selected []localSecret
+	found := make(map[string]bool)
+	for _, secret := range source {
+		if len(keys) > 0 && !wanted[secret.Key] {
+			continue
+		}
+		found[secret.Key] = true
+		if excluded[secret.Key] {
+			continue
+		}
+		selected = append(selected, localSecret{Key: secret.Key, Value: secret.Value, Location: secret.Location})
+	}
+
+	for _, key := range keys {
+		if !found[key] {
+			return nil, fmt.Errorf("secret %s does not exist", key)
+		}
+	}
+	return selected, nil
+}
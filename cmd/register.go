@@ -6,7 +6,6 @@ import (
 
 	"github.com/spf13/cobra"
 
-	dbpkg "github.com/spf13/sbx/db"
 	"github.com/spf13/sbx/helpers"
 )
 
@@ -15,31 +14,58 @@ var registerCmd = &cobra.Command{
 	Use:   "register",
 	Short: "Register a new user in the database",
 	Long: `The register command allows you to create a new user in the database.
-You need to provide an email, password, and specify if the user is an admin.`,
+You need to provide an email, password, and specify if the user is an admin.
+The first user registered in an empty database becomes an admin; after that
+only a logged in admin can register new users.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		helpers.CheckIfStarted(started)
-
 		email, _ := cmd.Flags().GetString("email")
 		password, _ := cmd.Flags().GetString("password")
 		admin, _ := cmd.Flags().GetBool("admin")
 
-		if email == "" || password == "" {
-			fmt.Println("Email and password are required")
+		if email == "" {
+			fmt.Println("Email is required")
 			os.Exit(1)
 		}
 
-		db, err := dbpkg.ConnectToDB()
+		if password == "" {
+			var err error
+			password, err = helpers.PromptPassword("Password: ")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			if password == "" {
+				fmt.Println("Password is required")
+				os.Exit(1)
+			}
+		}
+
+		db, err := connectStore()
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to connect to the database: %v\n", err)
 			os.Exit(1)
 		}
 		defer db.Close()
 
-		err = dbpkg.CreateUser(db, email, password, admin)
+		// Bootstrap: the first user is always an admin, everyone else must be
+		// registered by an admin
+		userCount, err := db.CountUsers()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to register user: %v\n", err)
+			os.Exit(1)
+		}
+		if userCount == 0 {
+			admin = true
+		} else {
+			requireAdmin(db)
+		}
+
+		err = db.CreateUser(email, password, admin)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to register user: %v\n", err)
 			os.Exit(1)
 		}
+		fmt.Println("User created successfully")
 	},
 }
 
@@ -48,6 +74,6 @@ func init() {
 
 	// Flags for the register command
 	registerCmd.Flags().StringP("email", "e", "", "Email address of the user")
-	registerCmd.Flags().StringP("password", "p", "", "Password for the user")
+	registerCmd.Flags().StringP("password", "p", "", "Password for the user (prompted for if omitted)")
 	registerCmd.Flags().BoolP("admin", "a", false, "Set user as admin")
 }
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/spf13/sbx/helpers"
+)
+
+// rollbackCmd represents the rollback command
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback [KEY]",
+	Short: "Restore a secret or a whole environment to an earlier state",
+	Long: `The rollback command restores earlier values from the version history.
+
+  sbx rollback KEY --to N --prod    restores version N of a single secret
+  sbx rollback --at TIME --prod     restores every secret of the environment
+                                    to its state at TIME
+
+When the key is stored in several locations, --location selects which one.
+TIME accepts RFC 3339 timestamps, dates (YYYY-MM-DD) or durations such as 2h
+meaning that long ago. Rollbacks are recorded as new versions, so they can be
+rolled back themselves.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: completeArgs(1, completeKeys),
+	Run: func(cmd *cobra.Command, args []string) {
+		projectName, _ := cmd.Flags().GetString("project")
+		toVersion, _ := cmd.Flags().GetInt("to")
+		at, _ := cmd.Flags().GetString("at")
+		location, _ := cmd.Flags().GetString("location")
+
+		switch {
+		case len(args) == 1 && toVersion > 0 && at == "":
+		case len(args) == 0 && toVersion == 0 && at != "":
+		default:
+			fmt.Println("Use either 'rollback KEY --to N' or 'rollback --at TIME'")
+			os.Exit(1)
+		}
+
+		if projectName == "" {
+			projectName = defaultProject(os.Stdout)
+		}
+
+		environmentType := environmentFromFlags(cmd)
+
+		db, err := connectStore()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to connect to the database: %v\n", err)
+			os.Exit(1)
+		}
+		defer db.Close()
+
+		// Only authenticated users may proceed
+		user := requireUser(db)
+
+		if len(args) == 1 {
+			key := args[0]
+			err = db.RollbackSecret(user, key, location, projectName, environmentType, toVersion)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to roll back %s: %v\n", key, err)
+				os.Exit(1)
+			}
+			fmt.Printf("Rolled back %s to version %d\n", key, toVersion)
+			return
+		}
+
+		pointInTime, err := helpers.ParseTime(at)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --at: %v\n", err)
+			os.Exit(1)
+		}
+
+		changed, err := db.RollbackEnvironment(user, projectName, environmentType, pointInTime)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to roll back %s: %v\n", environmentType, err)
+			os.Exit(1)
+		}
+		for _, ref := range changed {
+			fmt.Printf("Rolled back secret: %s\n", secretLabel(ref.Key, ref.Location))
+		}
+		if len(changed) == 0 {
+			fmt.Printf("The %s environment already matches its state at %s\n", environmentType, pointInTime.Format("2006-01-02 15:04:05"))
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rollbackCmd)
+
+	// Flags for the rollback command
+	rollbackCmd.Flags().StringP("project", "p", "", "Project name")
+	addEnvironmentFlags(rollbackCmd, "Roll back in", "s")
+	rollbackCmd.Flags().Int("to", 0, "Version of the secret to restore")
+	rollbackCmd.Flags().StringP("location", "l", "", "Location of the secret when the key is stored in several (e.g. '.' or 'api')")
+	rollbackCmd.Flags().String("at", "", "Restore the whole environment to its state at this time")
+}
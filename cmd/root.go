@@ -19,12 +19,22 @@ With SecretBase, you can easily create projects, share secrets, and ensure consi
 across your environments with minimal effort.`,
 }
 
+// errorStatus is the exit status of a command that fails. diff raises it to 2,
+// as diff(1) does, so that its status 1 only ever means differences were found.
+var errorStatus = 1
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	err := rootCmd.Execute()
+	registerCompletions(rootCmd)
+
+	cmd, err := rootCmd.ExecuteC()
 	if err != nil {
-		os.Exit(1)
+		// Usage errors are reported here, before the command has run
+		if cmd == diffCmd {
+			errorStatus = 2
+		}
+		os.Exit(errorStatus)
 	}
 }
 
@@ -34,6 +44,7 @@ func init() {
 	// will be global for your application.
 
 	// rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.sbx.yaml)")
+	rootCmd.PersistentFlags().StringVar(&remoteFlag, "remote", "", "URL of an sbx server to use instead of the database (default $SBX_REMOTE)")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
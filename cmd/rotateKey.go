@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// rotateKeyCmd represents the rotate command
+var rotateKeyCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Rotate the encryption key of a project",
+	Long: `The rotate command generates a new data key version for a project and
+re-encrypts every secret of the project with it. Secret values never leave
+the client in plaintext, so no secrets need to be shared again.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		projectName, _ := cmd.Flags().GetString("project")
+
+		if projectName == "" {
+			projectName = defaultProject(os.Stdout)
+		}
+
+		db, err := connectStore()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to connect to the database: %v\n", err)
+			os.Exit(1)
+		}
+		defer db.Close()
+
+		// Only authenticated users may proceed
+		user := requireUser(db)
+
+		// first ProjectExists check to make sure that we can proceed
+		projectExists, err := db.ProjectExists(projectName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error checking if project exists: %v\n", err)
+			os.Exit(1)
+		}
+		if !projectExists {
+			fmt.Fprintf(os.Stderr, "Project '%s' does not exist.\n", projectName)
+			os.Exit(1)
+		}
+
+		version, err := db.RotateProjectKey(user, projectName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to rotate key: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Project '%s' is now encrypted with key version %d\n", projectName, version)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rotateKeyCmd)
+
+	// Flags for the rotate command
+	rotateKeyCmd.Flags().StringP("project", "p", "", "Project name")
+}
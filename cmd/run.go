@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	dbpkg "github.com/spf13/sbx/db"
+)
+
+// runCmd represents the run command
+var runCmd = &cobra.Command{
+	Use:   "run [flags] -- COMMAND [ARGS...]",
+	Short: "Run a command with the secrets of an environment",
+	Long: `The run command fetches the secrets of a project environment and starts
+COMMAND with them added to its environment, overriding variables of the same
+name. Nothing is written to disk. Signals received by sbx are forwarded to the
+command, and sbx exits with the command's exit code. The credentials of sbx
+itself, such as SBX_MASTER_KEY and SBX_TOKEN, are not passed on to it.
+
+Secrets from every location are injected unless --location selects one.
+In CI, authenticate with a service token in SBX_TOKEN, see 'sbx token'.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		projectName, _ := cmd.Flags().GetString("project")
+		location, _ := cmd.Flags().GetString("location")
+
+		// Stdout belongs to the command, so sbx only reports on stderr
+		if projectName == "" {
+			projectName = defaultProject(os.Stderr)
+		}
+
+		environmentType := environmentFromFlags(cmd)
+
+		db, err := connectStore()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to connect to the database: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Only authenticated users may proceed
+		user := requireUser(db)
+		requireEnvironment(db, projectName, environmentType)
+
+		secrets, err := db.GetSecrets(user, projectName, environmentType)
+		db.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to fetch secrets: %v\n", err)
+			os.Exit(1)
+		}
+
+		env, err := secretEnvironment(secrets, location)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to prepare environment: %v\n", err)
+			os.Exit(1)
+		}
+
+		os.Exit(runWithEnvironment(args[0], args[1:], env))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(runCmd)
+
+	// Everything after the command name belongs to the command
+	runCmd.Flags().SetInterspersed(false)
+
+	// Flags for the run command
+	runCmd.Flags().StringP("project", "p", "", "Project name")
+	addEnvironmentFlags(runCmd, "Run with secrets of", "s")
+	runCmd.Flags().StringP("location", "l", "", "Only inject secrets stored for this location (e.g. '.' or 'api')")
+}
+
+// secretEnvironment turns secrets into KEY=VALUE pairs
+func secretEnvironment(secrets []dbpkg.Secret, location string) ([]string, error) {
+	selected, err := selectSecrets(secrets, location)
+	if err != nil {
+		return nil, err
+	}
+
+	var env []string
+	for _, secret := range selected {
+		env = append(env, secret.Key+"="+secret.Value)
+	}
+	sort.Strings(env)
+	return env, nil
+}
+
+// childEnvironment returns the environment of sbx without the credentials sbx
+// itself uses, for commands run with secrets
+func childEnvironment() []string {
+	credentials := append([]string{"SBX_TOKEN"}, dbpkg.ConfigVariables...)
+
+	var env []string
+	for _, variable := range os.Environ() {
+		name, _, _ := strings.Cut(variable, "=")
+		if !slices.Contains(credentials, name) {
+			env = append(env, variable)
+		}
+	}
+	return env
+}
+
+// runWithEnvironment runs a command with extra environment variables, relaying
+// signals to it until it exits, and returns the exit code sbx should exit with
+func runWithEnvironment(name string, args []string, env []string) int {
+	child := exec.Command(name, args...)
+	child.Env = append(childEnvironment(), env...)
+	child.Stdin = os.Stdin
+	child.Stdout = os.Stdout
+	child.Stderr = os.Stderr
+
+	// Take over signals before starting the command so none are missed
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	defer signal.Stop(signals)
+
+	if err := child.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to start %s: %v\n", name, err)
+		if errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+			return 127
+		}
+		return 126
+	}
+
+	go func() {
+		for sig := range signals {
+			_ = child.Process.Signal(sig)
+		}
+	}()
+
+	err := child.Wait()
+	if err == nil {
+		return 0
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		fmt.Fprintf(os.Stderr, "Failed to run %s: %v\n", strings.Join(append([]string{name}, args...), " "), err)
+		return 1
+	}
+
+	// Follow the shell convention for commands killed by a signal
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return exitErr.ExitCode()
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/spf13/sbx/api"
+	dbpkg "github.com/spf13/sbx/db"
+)
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the SecretBase API over HTTP",
+	Long: `The serve command runs an sbx server: a JSON API over the configured database,
+so that developers and CI authenticate to the server with 'sbx login' instead
+of holding database credentials. Point the CLI at it with --remote URL or
+SBX_REMOTE:
+
+  sbx serve --addr :8443 --tls-cert cert.pem --tls-key key.pem
+  sbx --remote https://sbx.example.com:8443 login -e me@example.com
+
+The server needs the database settings and SBX_MASTER_KEY; clients need
+neither. Without a certificate the API is served over plain HTTP, which should
+only be used behind a proxy that terminates TLS.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		addr, _ := cmd.Flags().GetString("addr")
+		certFile, _ := cmd.Flags().GetString("tls-cert")
+		keyFile, _ := cmd.Flags().GetString("tls-key")
+
+		if (certFile == "") != (keyFile == "") {
+			fmt.Println("--tls-cert and --tls-key must be given together")
+			os.Exit(1)
+		}
+
+		db, err := dbpkg.ConnectToDB()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to connect to the database: %v\n", err)
+			os.Exit(1)
+		}
+		defer db.Close()
+
+		logger := log.New(os.Stderr, "", log.LstdFlags)
+		server := &http.Server{
+			Addr:              addr,
+			Handler:           logRequests(logger, api.NewServer(db)),
+			ReadHeaderTimeout: 10 * time.Second,
+			ErrorLog:          logger,
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		go func() {
+			<-ctx.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			server.Shutdown(shutdownCtx)
+		}()
+
+		if certFile != "" {
+			logger.Printf("Serving the sbx API on https://%s", addr)
+			err = server.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			logger.Printf("Serving the sbx API on http://%s without TLS", addr)
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Fprintf(os.Stderr, "Server failed: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// statusRecorder remembers the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// logRequests logs the method, path, status and duration of every request.
+// Query strings are left out of the log.
+func logRequests(logger *log.Logger, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(recorder, r)
+		logger.Printf("%s %s %d %s", r.Method, r.URL.Path, recorder.status, time.Since(start).Round(time.Millisecond))
+	})
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+
+	// Flags for the serve command
+	serveCmd.Flags().String("addr", "localhost:8080", "Address to listen on")
+	serveCmd.Flags().String("tls-cert", "", "TLS certificate file")
+	serveCmd.Flags().String("tls-key", "", "TLS private key file")
+}
@@ -11,20 +11,18 @@ import (
 	"strings"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/sbx/helpers"
+	"github.com/spf13/sbx/dotenv"
 )
 
 // setupCmd represents the setup command
 var setupCmd = &cobra.Command{
 	Use:   "setup",
 	Short: "Sets up the environment by creating and updating .env and .env.example files.",
-	Long: `The setup command scans the current directory for .env files,
+	Long: `The setup command scans the project for .env files,
 extracts the keys, and creates or updates corresponding .env.example files.
 Existing values in .env.example files are preserved where applicable,
 and any keys not present in the .env file are removed.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		helpers.CheckIfStarted(started)
-
 		processEnvFiles()
 	},
 }
@@ -33,35 +31,27 @@ func init() {
 	rootCmd.AddCommand(setupCmd)
 }
 
-// Extract keys and preserve the entire line including comments on the same line as key-value pairs
-func extractKeys(content string) map[string]string {
-	keys := make(map[string]string)
-	lines := strings.Split(content, "\n")
-	for _, line := range lines {
-		trimmedLine := strings.TrimSpace(line)
-		if strings.Contains(trimmedLine, "=") {
-			// Handle key-value pairs
-			key := strings.SplitN(trimmedLine, "=", 2)[0]
-			keys[key] = trimmedLine
-		}
+// Extract the definitions of a .env file by key; later definitions of a key win
+func extractKeys(content string) (map[string]dotenv.Entry, error) {
+	entries, err := dotenv.Parse(strings.NewReader(content))
+	if err != nil {
+		return nil, err
 	}
-	return keys
+
+	keys := make(map[string]dotenv.Entry)
+	for _, entry := range entries {
+		keys[entry.Key] = entry
+	}
+	return keys, nil
 }
 
-// Create a new line for the .env.example file
-func createNewLine(key, line string) string {
-	// Handle key-value pairs with comments on the same line
-	keyValue := strings.SplitN(line, "=", 2)
-	value := keyValue[1]
-	commentIndex := strings.Index(value, "#")
-	if commentIndex != -1 {
-		// Preserve comments and set the value to ''
-		comment := value[commentIndex:]
-		return key + "='' " + comment
-	} else {
-		// Set the value to ''
-		return key + "=''"
+// Create a new line for the .env.example file, keeping the inline comment of the .env entry
+func createNewLine(entry dotenv.Entry) string {
+	line := entry.Key + "=''"
+	if entry.Comment != "" {
+		line += " # " + entry.Comment
 	}
+	return line
 }
 
 // Write the .env.example file with the given content
@@ -71,7 +61,7 @@ func writeEnvExampleFile(path string, lines []string) error {
 }
 
 // Update the .env.example file based on the .env file
-func updateEnvExampleFile(envKeys map[string]string, exampleFilePath string) error {
+func updateEnvExampleFile(envKeys map[string]dotenv.Entry, exampleFilePath string) error {
 	var updatedExampleLines []string
 
 	// Sort keys alphabetically
@@ -85,7 +75,7 @@ func updateEnvExampleFile(envKeys map[string]string, exampleFilePath string) err
 	if _, err := os.Stat(exampleFilePath); os.IsNotExist(err) {
 		// If it doesn't exist, create the .env.example file with empty values
 		for _, key := range sortedKeys {
-			updatedExampleLines = append(updatedExampleLines, createNewLine(key, envKeys[key]))
+			updatedExampleLines = append(updatedExampleLines, createNewLine(envKeys[key]))
 		}
 	} else {
 		// If it exists, read its content
@@ -96,16 +86,19 @@ func updateEnvExampleFile(envKeys map[string]string, exampleFilePath string) err
 		}
 
 		// Extract existing keys and lines from the .env.example file
-		exampleKeys := extractKeys(string(exampleContentBytes))
+		exampleKeys, err := extractKeys(string(exampleContentBytes))
+		if err != nil {
+			return fmt.Errorf("error parsing %s: %v", exampleFilePath, err)
+		}
 
 		// Add all keys from the .env file in order, and preserve existing values if they exist
 		for _, key := range sortedKeys {
-			if existingLine, exists := exampleKeys[key]; exists {
+			if existing, exists := exampleKeys[key]; exists {
 				// Preserve the existing value from the .env.example file if it exists
-				updatedExampleLines = append(updatedExampleLines, existingLine)
+				updatedExampleLines = append(updatedExampleLines, existing.Raw)
 			} else {
 				// Add the key with an empty value and preserve any comments
-				updatedExampleLines = append(updatedExampleLines, createNewLine(key, envKeys[key]))
+				updatedExampleLines = append(updatedExampleLines, createNewLine(envKeys[key]))
 			}
 		}
 	}
@@ -115,58 +108,45 @@ func updateEnvExampleFile(envKeys map[string]string, exampleFilePath string) err
 }
 
 func processEnvFiles() {
-	// Get the current working directory
-	root, err := os.Getwd()
+	cfg := projectConfig()
+
+	// Print the name of the project directory
+	fmt.Println("Operating in directory:", filepath.Base(cfg.Root))
+
+	files, err := cfg.EnvFiles()
 	if err != nil {
-		fmt.Println("Error getting the current working directory:", err)
+		fmt.Println("Error walking the directory:", err)
 		return
 	}
 
-	// Print the name of the current directory
-	currentDir := filepath.Base(root)
-	fmt.Println("Operating in directory:", currentDir)
+	for _, file := range files {
+		// Print the relative path with a leading slash
+		fmt.Println("/" + file)
+
+		path := filepath.Join(cfg.Root, filepath.FromSlash(file))
 
-	// Walk through the directory recursively
-	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		// Read the contents of the .env file
+		content, err := os.ReadFile(path)
 		if err != nil {
-			return err
+			fmt.Println("Error reading .env file:", err)
+			return
 		}
 
-		// Check if the file has a .env extension
-		if filepath.Ext(info.Name()) == ".env" {
-			// Calculate the relative path
-			relativePath, err := filepath.Rel(root, path)
-			if err != nil {
-				return err
-			}
-
-			// Print the relative path with a leading slash
-			fmt.Println("/" + relativePath)
-
-			// Read the contents of the .env file
-			content, err := os.ReadFile(path)
-			if err != nil {
-				return err
-			}
-
-			// Extract keys and lines from the .env file
-			envKeys := extractKeys(string(content))
-
-			// Create the .env.example file path
-			exampleFilePath := strings.TrimSuffix(path, ".env") + ".env.example"
-
-			// Update the .env.example file
-			err = updateEnvExampleFile(envKeys, exampleFilePath)
-			if err != nil {
-				fmt.Println("Error updating .env.example file:", err)
-				return err
-			}
+		// Extract keys and lines from the .env file
+		envKeys, err := extractKeys(string(content))
+		if err != nil {
+			fmt.Printf("Error parsing %s: %v\n", path, err)
+			return
 		}
 
-		return nil
-	})
+		// Create the .env.example file path
+		exampleFilePath := strings.TrimSuffix(path, ".env") + ".env.example"
 
-	if err != nil {
-		fmt.Println("Error walking the directory:", err)
+		// Update the .env.example file
+		err = updateEnvExampleFile(envKeys, exampleFilePath)
+		if err != nil {
+			fmt.Println("Error updating .env.example file:", err)
+			return
+		}
 	}
 }
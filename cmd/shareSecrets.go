@@ -1,16 +1,16 @@
 package cmd
 
 import (
-	"bufio"
-	"database/sql"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 
 	"github.com/spf13/cobra"
 
 	dbpkg "github.com/spf13/sbx/db"
+	"github.com/spf13/sbx/dotenv"
 	"github.com/spf13/sbx/helpers"
 )
 
@@ -18,46 +18,34 @@ var shareSecretsCmd = &cobra.Command{
 	Use:   "share",
 	Short: "Add, update, or delete secrets based on .env files for a specific environment",
 	Long: `The share command allows you to add, update, or delete key/value pairs 
-from .env files into the database for the specified project and environment.`,
+from .env files into the database for the specified project and environment.
+Before anything is written the changes are shown as a diff with values masked.
+Use --dry-run to only show the diff. Changes to protected environments, such as
+staging and production, must be confirmed interactively unless --yes is given.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		projectName, _ := cmd.Flags().GetString("project")
-		isDev, _ := cmd.Flags().GetBool("dev")
-		isStaging, _ := cmd.Flags().GetBool("staging")
-		isProd, _ := cmd.Flags().GetBool("prod")
 		secretPair, _ := cmd.Flags().GetString("secret")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		yes, _ := cmd.Flags().GetBool("yes")
 
 		if projectName == "" {
-			var err error
-			projectName, err = helpers.GetCurrentDirName()
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to determine project name: %v\n", err)
-				os.Exit(1)
-			}
-			fmt.Printf("Using current directory name as project name: %s\n", projectName)
+			projectName = defaultProject(os.Stdout)
 		}
 
-		var environmentType string
-		switch {
-		case isDev:
-			environmentType = "development"
-		case isStaging:
-			environmentType = "staging"
-		case isProd:
-			environmentType = "production"
-		default:
-			fmt.Println("You must specify one of the following flags: --dev, --staging, or --prod")
-			os.Exit(1)
-		}
+		environmentType := environmentFromFlags(cmd)
 
-		db, err := dbpkg.ConnectToDB()
+		db, err := connectStore()
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to connect to the database: %v\n", err)
 			os.Exit(1)
 		}
 		defer db.Close()
 
+		// Only authenticated users may proceed
+		user := requireUser(db)
+
 		// first ProjectExists check to make sure that we can proceed
-		projectExists, err := dbpkg.ProjectExists(db, projectName)
+		projectExists, err := db.ProjectExists(projectName)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error checking if project exists: %v\n", err)
 			os.Exit(1)
@@ -66,15 +54,47 @@ from .env files into the database for the specified project and environment.`,
 			fmt.Fprintf(os.Stderr, "Project '%s' does not exist.\n", projectName)
 			os.Exit(1)
 		}
+		environment := requireEnvironment(db, projectName, environmentType)
 
+		var local []localSecret
 		if secretPair != "" {
 			// Handle single key/value pair passed via --secret
-			err = handleSingleSecret(db, projectName, environmentType, secretPair)
+			var secret localSecret
+			secret, err = parseSingleSecret(secretPair)
+			local = []localSecret{secret}
 		} else {
 			// Handle .env files
-			err = handleEnvFiles(db, projectName, environmentType)
+			local, err = readLocalEnvFiles()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to process secrets: %v\n", err)
+			os.Exit(1)
+		}
+
+		remote, err := db.GetSecrets(user, projectName, environmentType)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to fetch secrets: %v\n", err)
+			os.Exit(1)
 		}
 
+		// A full share mirrors the local files, so keys missing locally are removed
+		changes := diffSecrets(local, remote, environmentType, secretPair == "")
+		if len(changes) == 0 {
+			fmt.Printf("The %s environment is already up to date\n", environmentType)
+			return
+		}
+
+		printChanges(changes, helpers.MaskValue)
+		if dryRun {
+			fmt.Println("Dry run: no changes were written")
+			return
+		}
+		if !confirmChanges(environment, changes, yes) {
+			fmt.Println("Aborted: no changes were written")
+			os.Exit(1)
+		}
+
+		err = applyChanges(db, user, projectName, environmentType, changes)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to process secrets: %v\n", err)
 			os.Exit(1)
@@ -87,165 +107,72 @@ func init() {
 
 	// Flags for the add secrets command
 	shareSecretsCmd.Flags().StringP("project", "p", "", "Project name")
-	shareSecretsCmd.Flags().BoolP("dev", "d", false, "Add secrets for the development environment")
-	shareSecretsCmd.Flags().BoolP("staging", "g", false, "Add secrets for the staging environment")
-	shareSecretsCmd.Flags().BoolP("prod", "r", false, "Add secrets for the production environment")
+	addEnvironmentFlags(shareSecretsCmd, "Add secrets for", "g")
 	shareSecretsCmd.Flags().StringP("secret", "s", "", "Single key=value pair to add or update as a secret")
+	shareSecretsCmd.Flags().Bool("dry-run", false, "Show what would change without writing anything")
+	shareSecretsCmd.Flags().BoolP("yes", "y", false, "Apply changes to a protected environment without asking for confirmation")
 }
 
-func handleSingleSecret(db *sql.DB, projectName, environmentType, secretPair string) error {
+// parseSingleSecret parses the key=value pair passed via --secret
+func parseSingleSecret(secretPair string) (localSecret, error) {
 	// Split the key=value pair
 	parts := strings.SplitN(secretPair, "=", 2)
 	if len(parts) != 2 {
-		return fmt.Errorf("invalid format for --secret flag. Expected format: key=value")
+		return localSecret{}, fmt.Errorf("invalid format for --secret flag. Expected format: key=value")
 	}
 
 	key := strings.TrimSpace(parts[0])
 	value := strings.TrimSpace(parts[1])
 
 	// Determine the location as the current directory
-	location := "."
-
-	// Check if the secret already exists
-	secretExists, err := dbpkg.SecretExists(db, key, projectName, environmentType)
-	if err != nil {
-		return fmt.Errorf("error checking if secret exists: %v", err)
-	}
-
-	if secretExists {
-		// Update existing secret
-		err = dbpkg.UpdateSecret(db, key, value, location, projectName, environmentType)
-		if err != nil {
-			return fmt.Errorf("error updating secret: %v", err)
-		}
-		fmt.Printf("Updated secret: %s\n", key)
-	} else {
-		// Insert new secret
-		err = dbpkg.CreateSecret(db, key, value, location, projectName, environmentType)
-		if err != nil {
-			return fmt.Errorf("error creating secret: %v", err)
-		}
-		fmt.Printf("Created new secret: %s\n", key)
-	}
-
-	return nil
+	return localSecret{Key: key, Value: value, Location: "."}, nil
 }
 
-func handleEnvFiles(db *sql.DB, projectName, environmentType string) error {
-	// Get the current working directory
-	root, err := os.Getwd()
+// readLocalEnvFiles collects the key/value pairs of every secret file of the
+// project, as selected by .sbx.yaml. Each file's location is its directory
+// relative to the project root.
+func readLocalEnvFiles() ([]localSecret, error) {
+	cfg := projectConfig()
+
+	files, err := cfg.EnvFiles()
 	if err != nil {
-		return fmt.Errorf("error getting the current working directory: %v", err)
+		return nil, err
 	}
 
-	// Track the keys found in local .env files
-	localKeys := make(map[string]bool)
+	var secrets []localSecret
+	for _, file := range files {
+		fullPath := filepath.Join(cfg.Root, filepath.FromSlash(file))
+		fmt.Printf("Processing file: %s\n", fullPath)
 
-	// Walk through the directory recursively
-	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		entries, err := dotenv.ParseFile(fullPath)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		// Check if the file has a .env extension
-		if filepath.Ext(info.Name()) == ".env" {
-			fmt.Printf("Processing file: %s\n", path)
-
-			// Determine the relative path to the .env file
-			relativePath, err := filepath.Rel(root, filepath.Dir(path))
-			if err != nil {
-				return fmt.Errorf("error calculating relative path: %v", err)
-			}
-
-			file, err := os.Open(path)
-			if err != nil {
-				return fmt.Errorf("error opening file %s: %v", path, err)
-			}
-			defer file.Close()
-
-			scanner := bufio.NewScanner(file)
-			for scanner.Scan() {
-				line := strings.TrimSpace(scanner.Text())
-
-				// Skip lines that are comments or empty
-				if strings.HasPrefix(line, "#") || line == "" {
-					continue
-				}
-
-				// Handle inline comments by stripping everything after the first #
-				if index := strings.Index(line, "#"); index != -1 {
-					line = strings.TrimSpace(line[:index])
-				}
-
-				parts := strings.SplitN(line, "=", 2)
-				if len(parts) != 2 {
-					continue
-				}
-				key := strings.TrimSpace(parts[0])
-				value := strings.TrimSpace(parts[1])
-
-				// Track this key as found locally
-				localKeys[key] = true
-
-				// Check if the secret already exists
-				secretExists, err := dbpkg.SecretExists(db, key, projectName, environmentType)
-				if err != nil {
-					return fmt.Errorf("error checking if secret exists: %v", err)
-				}
-
-				if secretExists {
-					// Update existing secret
-					err = dbpkg.UpdateSecret(db, key, value, relativePath, projectName, environmentType)
-					if err != nil {
-						return fmt.Errorf("error updating secret: %v", err)
-					}
-					fmt.Printf("Updated secret: %s\n", key)
-				} else {
-					// Insert new secret
-					err = dbpkg.CreateSecret(db, key, value, relativePath, projectName, environmentType)
-					if err != nil {
-						return fmt.Errorf("error creating secret: %v", err)
-					}
-					fmt.Printf("Created new secret: %s\n", key)
-				}
-			}
-
-			if err := scanner.Err(); err != nil {
-				return fmt.Errorf("error reading file %s: %v", path, err)
-			}
+		for _, entry := range entries {
+			secrets = append(secrets, localSecret{Key: entry.Key, Value: entry.Value, Location: path.Dir(file)})
 		}
-
-		return nil
-	})
-
-	if err != nil {
-		return err
 	}
 
-	// Delete secrets that are in the database but not in the local .env files
-	err = deleteUnusedSecrets(db, projectName, environmentType, localKeys)
-	if err != nil {
-		return fmt.Errorf("error deleting unused secrets: %v", err)
-	}
-
-	return nil
+	return secrets, nil
 }
 
-func deleteUnusedSecrets(db *sql.DB, projectName, environmentType string, localKeys map[string]bool) error {
-	// Get all keys from the database for the given project and environment
-	dbKeys, err := dbpkg.GetAllSecretsKeys(db, projectName, environmentType)
+// applyChanges writes a computed set of changes to the database in a single
+// transaction, so a failure part way leaves the environment untouched
+func applyChanges(db dbpkg.Store, user dbpkg.User, projectName, environmentType string, changes []secretChange) error {
+	err := db.ApplyChangeset(user, projectName, environmentType, toChangeset(changes))
 	if err != nil {
-		return fmt.Errorf("error fetching keys from database: %v", err)
+		return fmt.Errorf("error applying changes, nothing was written: %v", err)
 	}
 
-	// Delete keys that are in the database but not in the local .env files
-	for _, key := range dbKeys {
-		if !localKeys[key] {
-			err = dbpkg.DeleteSecret(db, key, projectName, environmentType)
-			if err != nil {
-				return fmt.Errorf("error deleting secret: %v", err)
-			}
-			fmt.Printf("Deleted unused secret: %s\n", key)
+	for _, change := range changes {
+		switch change.Kind {
+		case changeAdded:
+			fmt.Printf("Created new secret: %s\n", secretLabel(change.Key, change.Location))
+		case changeChanged:
+			fmt.Printf("Updated secret: %s\n", secretLabel(change.Key, change.Location))
+		case changeRemoved:
+			fmt.Printf("Deleted unused secret: %s\n", secretLabel(change.Key, change.Location))
 		}
 	}
 
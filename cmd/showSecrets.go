@@ -7,53 +7,49 @@ import (
 	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
 
-	dbpkg "github.com/spf13/sbx/db"
-	"github.com/spf13/sbx/helpers"
+	"github.com/spf13/sbx/format"
 )
 
 // showSecretsCmd represents the show secrets command
 var showSecretsCmd = &cobra.Command{
 	Use:   "secrets",
 	Short: "Show secrets for a specific environment",
-	Long:  `The show secrets command allows you to display the key/value pairs associated with a specific environment (dev, staging, prod) for a given project.`,
+	Long:  `The show secrets command allows you to display the key/value pairs associated with a specific environment (dev, staging, prod, or any environment created with 'sbx env create') for a given project.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		projectName, _ := cmd.Flags().GetString("project")
-		isDev, _ := cmd.Flags().GetBool("dev")
-		isStaging, _ := cmd.Flags().GetBool("staging")
-		isProd, _ := cmd.Flags().GetBool("prod")
+		formatName := formatFromFlags(cmd)
+		output, _ := cmd.Flags().GetString("output")
+		location, _ := cmd.Flags().GetString("location")
 
+		// Secrets may be exported to stdout, so notes are printed on stderr
 		if projectName == "" {
-			var err error
-			projectName, err = helpers.GetCurrentDirName()
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to determine project name: %v\n", err)
-				os.Exit(1)
-			}
-			fmt.Printf("Using current directory name as project name: %s\n", projectName)
+			projectName = defaultProject(os.Stderr)
 		}
 
-		var environmentType string
-		switch {
-		case isDev:
-			environmentType = "development"
-		case isStaging:
-			environmentType = "staging"
-		case isProd:
-			environmentType = "production"
-		default:
-			fmt.Println("You must specify one of the following flags: --dev, --staging, or --prod")
+		if formatName != "table" {
+			if _, err := format.Get(formatName); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+		} else if output != "" && output != "-" {
+			fmt.Println("The table format can only be shown on stdout, use --format to write a file")
 			os.Exit(1)
 		}
 
-		db, err := dbpkg.ConnectToDB()
+		environmentType := environmentFromFlags(cmd)
+
+		db, err := connectStore()
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to connect to the database: %v\n", err)
 			os.Exit(1)
 		}
 		defer db.Close()
 
+		// Only authenticated users may proceed
+		user := requireUser(db)
+
 		// first ProjectExists check to make sure that we can proceed
-		projectExists, err := dbpkg.ProjectExists(db, projectName)
+		projectExists, err := db.ProjectExists(projectName)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error checking if project exists: %v\n", err)
 			os.Exit(1)
@@ -62,39 +58,45 @@ var showSecretsCmd = &cobra.Command{
 			fmt.Fprintf(os.Stderr, "Project '%s' does not exist.\n", projectName)
 			os.Exit(1)
 		}
+		requireEnvironment(db, projectName, environmentType)
 
-		rows, err := db.Query(`
-			SELECT s.key, s.value
-			FROM secrets s
-			INNER JOIN environment_secrets es ON s.id = es.secret_id
-			INNER JOIN environments e ON es.environment_id = e.id
-			INNER JOIN projects p ON e.project_id = p.id
-			WHERE p.name = ? AND e.environment_type = ?`, projectName, environmentType)
-
+		secrets, err := db.GetSecrets(user, projectName, environmentType)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to execute query: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Failed to fetch secrets: %v\n", err)
+			return
+		}
+
+		if formatName != "table" {
+			secrets, err = selectSecrets(secrets, location)
+			if err == nil {
+				meta := format.Meta{Project: projectName, Environment: environmentType}
+				err = writeFormatted(formatName, output, meta, secrets)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to export secrets: %v\n", err)
+				os.Exit(1)
+			}
 			return
 		}
-		defer rows.Close()
 
 		// Create a table to display the results
 		table := tablewriter.NewWriter(os.Stdout)
-		table.SetHeader([]string{"Key", "Value"})
-
-		for rows.Next() {
-			var key string
-			var value string
-			if err := rows.Scan(&key, &value); err != nil {
-				fmt.Fprintf(os.Stderr, "Error scanning row: %v\n", err)
-				return
-			}
+		table.SetHeader([]string{"Key", "Value", "Location", "Source", "Updated By", "Updated At"})
 
-			table.Append([]string{key, value})
-		}
+		for _, secret := range secrets {
+			if location != "" && secret.Location != location {
+				continue
+			}
+			updatedBy := secret.UpdatedBy.Email
+			if updatedBy == "" {
+				updatedBy = "-"
+			}
+			updatedAt := "-"
+			if !secret.UpdatedAt.IsZero() {
+				updatedAt = secret.UpdatedAt.Local().Format("2006-01-02 15:04:05")
+			}
 
-		if err := rows.Err(); err != nil {
-			fmt.Fprintf(os.Stderr, "Error during rows iteration: %v\n", err)
-			return
+			table.Append([]string{secret.Key, secret.Value, secret.Location, secret.Source, updatedBy, updatedAt})
 		}
 
 		// Render the table to stdout
@@ -107,7 +109,6 @@ func init() {
 
 	// Flags for the show secrets command
 	showSecretsCmd.Flags().StringP("project", "p", "", "Project name")
-	showSecretsCmd.Flags().BoolP("dev", "d", false, "Show secrets for the development environment")
-	showSecretsCmd.Flags().BoolP("staging", "s", false, "Show secrets for the staging environment")
-	showSecretsCmd.Flags().BoolP("prod", "r", false, "Show secrets for the production environment")
+	addEnvironmentFlags(showSecretsCmd, "Show secrets for", "s")
+	addOutputFlags(showSecretsCmd, "table")
 }
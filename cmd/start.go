@@ -2,48 +2,248 @@ package cmd
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
 
+	"github.com/mattn/go-shellwords"
+	"github.com/peterh/liner"
 	"github.com/spf13/cobra"
-)
 
-// a global variable to track if the CLI has started
-var started bool
+	"github.com/spf13/sbx/helpers"
+)
 
 // startCmd represents the start command
 var startCmd = &cobra.Command{
 	Use:   "start",
-	Short: "Start the interactive CLI for SecretBase",
-	Long: `Start the interactive CLI for SecretBase, allowing you to enter commands continuously
-until you decide to exit.`,
+	Short: "Start an interactive shell for SecretBase",
+	Long: `Start an interactive shell for SecretBase, allowing you to enter commands continuously
+until you decide to exit. The shell is optional: every command can also be run
+directly, e.g. 'sbx grab --dev', which is what scripts and CI should do.
+
+Arguments are quoted as in a Unix shell, e.g. share -s "GREETING=hello world".
+Tab completes commands, flags and the names of projects, environments and keys.
+The arrow keys recall earlier commands, which are kept in ~/.sbx/history;
+command lines that include a password are left out.`,
+	Args: cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		started = true
-		reader := bufio.NewReader(os.Stdin)
+		executable, err := os.Executable()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to start the shell: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Commands run with the terminal as it was, not as line editing sets it up
+		terminalMode, _ := liner.TerminalMode()
+		line := liner.NewLiner()
+		defer line.Close()
+		shellMode, _ := liner.TerminalMode()
+		line.SetCtrlCAborts(true)
+		line.SetWordCompleter(func(input string, pos int) (string, []string, string) {
+			return completeLine(executable, input, pos)
+		})
+		loadHistory(line)
+		defer saveHistory(line)
+
 		for {
-			fmt.Print("sbx> ")
-			input, _ := reader.ReadString('\n')
+			input, err := line.Prompt("sbx> ")
+			if errors.Is(err, liner.ErrPromptAborted) {
+				continue
+			}
+			if errors.Is(err, io.EOF) {
+				fmt.Println()
+				break
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to read input: %v\n", err)
+				break
+			}
+
 			input = strings.TrimSpace(input)
+			if input == "" {
+				continue
+			}
 
 			// Allow user to exit the loop
 			if input == "exit" || input == "quit" {
-				fmt.Println("Exiting SecretBase CLI...")
 				break
 			}
 
-			// Execute the command entered by the user
-			if input != "" {
-				args := strings.Split(input, " ")
-				rootCmd.SetArgs(args)
-				if err := rootCmd.Execute(); err != nil {
-					fmt.Printf("Error: %v\n", err)
-				}
+			args, err := shellwords.Parse(input)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid input: %v\n", err)
+				continue
+			}
+			if !hasPassword(args) {
+				line.AppendHistory(input)
+			}
+
+			if found, _, err := rootCmd.Find(args); err == nil && found == cmd {
+				fmt.Println("The SecretBase shell is already running.")
+				continue
+			}
+
+			if terminalMode != nil {
+				terminalMode.ApplyMode()
+			}
+			runShellCommand(executable, args)
+			if shellMode != nil {
+				shellMode.ApplyMode()
 			}
 		}
+		fmt.Println("Exiting SecretBase CLI...")
 	},
 }
 
+// shellEnv is the environment of the commands run by the shell. They talk to
+// the same sbx server as the shell itself.
+func shellEnv() []string {
+	env := os.Environ()
+	if remoteFlag != "" {
+		env = append(env, "SBX_REMOTE="+remoteFlag)
+	}
+	return env
+}
+
+// runShellCommand runs a command of the shell in a new sbx process, so that
+// every command starts out with fresh flags and one that fails and exits
+// doesn't end the shell
+func runShellCommand(executable string, args []string) {
+	child := exec.Command(executable, args...)
+	child.Stdin = os.Stdin
+	child.Stdout = os.Stdout
+	child.Stderr = os.Stderr
+	child.Env = shellEnv()
+
+	// Ctrl-C interrupts the command, e.g. one started with 'run', but not the shell
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
+	err := child.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		fmt.Fprintf(os.Stderr, "Failed to run command: %v\n", err)
+	}
+}
+
+// completeLine completes the word before the cursor, at rune offset pos, with
+// the completions that 'sbx completion' sets up for Unix shells
+func completeLine(executable, input string, pos int) (string, []string, string) {
+	runes := []rune(input)
+	head, tail := string(runes[:pos]), string(runes[pos:])
+
+	start := strings.LastIndexAny(head, " \t") + 1
+	args, err := shellwords.Parse(head[:start])
+	if err != nil {
+		return head, nil, tail
+	}
+	args = append([]string{cobra.ShellCompRequestCmd}, args...)
+	args = append(args, head[start:])
+
+	child := exec.Command(executable, args...)
+	child.Env = shellEnv()
+	output, err := child.Output()
+	if err != nil {
+		return head, nil, tail
+	}
+
+	// The output lists a completion per line, optionally followed by a tab and
+	// a description, and ends with a line holding the directive as ":N"
+	var values []string
+	suffix := " "
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		text := scanner.Text()
+		if directive, found := strings.CutPrefix(text, ":"); found {
+			if n, err := strconv.Atoi(directive); err == nil && cobra.ShellCompDirective(n)&cobra.ShellCompDirectiveNoSpace != 0 {
+				suffix = ""
+			}
+			continue
+		}
+		value, _, _ := strings.Cut(text, "\t")
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+
+	completions := make([]string, len(values))
+	for i, value := range values {
+		completions[i] = value + suffix
+	}
+	return head[:start], completions, tail
+}
+
+// hasPassword reports whether a command line includes a password, which must
+// not end up in the history
+func hasPassword(args []string) bool {
+	found, flags, err := rootCmd.Find(args)
+	if err != nil || found.Flags().Lookup("password") == nil {
+		return false
+	}
+	for _, arg := range flags {
+		if strings.HasPrefix(arg, "--password") || (strings.HasPrefix(arg, "-p") && !strings.HasPrefix(arg, "--")) {
+			return true
+		}
+	}
+	return false
+}
+
+// historyPath returns the file the shell history is kept in
+func historyPath() (string, error) {
+	dir, err := helpers.ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "history"), nil
+}
+
+// loadHistory reads the history of earlier shells, if there is one
+func loadHistory(line *liner.State) {
+	path, err := historyPath()
+	if err != nil {
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	line.ReadHistory(file)
+}
+
+// saveHistory writes the history for the next shell, readable only by the
+// current user
+func saveHistory(line *liner.State) {
+	path, err := historyPath()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save history: %v\n", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create %s: %v\n", filepath.Dir(path), err)
+		return
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save history: %v\n", err)
+		return
+	}
+	defer file.Close()
+
+	if _, err := line.WriteHistory(file); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save history: %v\n", err)
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(startCmd)
 }
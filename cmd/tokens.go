@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+
+	"github.com/spf13/sbx/helpers"
+)
+
+// tokenCmd groups the service token commands
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Manage service tokens for CI and machines",
+	Long: `The token command manages service tokens: credentials for CI pipelines and
+other machines that give access to one project, optionally to a single
+environment of it, without a user's password. Set a token in SBX_TOKEN and
+commands such as grab and run use it instead of a login session:
+
+  SBX_TOKEN=sbx_... sbx grab --project api --env production -o .env
+
+A token acts on behalf of the user who created it and never allows more than
+that user currently may. Read-only tokens can only read secrets, others can
+also write them. Creating, listing and revoking tokens requires the
+maintainer role.`,
+}
+
+// tokenCreateCmd represents the token create command
+var tokenCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a service token",
+	Long: `Create a service token for a project. Without --env the token may access every
+environment the creator can. The token is only shown once: store it in your CI
+system's secret store right away.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		projectName := projectFromFlags(cmd)
+		environment, _ := cmd.Flags().GetString("env")
+		name, _ := cmd.Flags().GetString("name")
+		readOnly, _ := cmd.Flags().GetBool("read-only")
+		ttlValue, _ := cmd.Flags().GetString("ttl")
+
+		ttl, err := helpers.ParseDuration(ttlValue)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --ttl: %v\n", err)
+			os.Exit(1)
+		}
+
+		db, err := connectStore()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to connect to the database: %v\n", err)
+			os.Exit(1)
+		}
+		defer db.Close()
+
+		// Only authenticated users may proceed
+		user := requireUser(db)
+
+		token, info, err := db.CreateServiceToken(user, projectName, environment, name, readOnly, ttl)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create service token: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Fprintf(os.Stderr, "Created %s service token %s for %s, expires %s\n",
+			tokenAccess(info.ReadOnly), info.Label(), tokenScope(info.Project, info.Environment), info.ExpiresAt.Local().Format("2006-01-02 15:04"))
+		fmt.Fprintln(os.Stderr, "Store it now, it will not be shown again:")
+		fmt.Println(token)
+	},
+}
+
+// tokenListCmd represents the token list command
+var tokenListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the service tokens of a project",
+	Long:  `List the service tokens of a project, including expired and revoked ones.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		projectName := projectFromFlags(cmd)
+
+		db, err := connectStore()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to connect to the database: %v\n", err)
+			os.Exit(1)
+		}
+		defer db.Close()
+
+		// Only authenticated users may proceed
+		user := requireUser(db)
+
+		tokens, err := db.ListServiceTokens(user, projectName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to list service tokens: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Create a table to display the results
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"ID", "Name", "Environment", "Access", "Created By", "Expires", "Last Used", "Status"})
+
+		now := time.Now()
+		for _, token := range tokens {
+			environment := "all"
+			if token.Environment != "" {
+				environment = token.Environment
+			}
+			lastUsed := "never"
+			if token.LastUsedAt != nil {
+				lastUsed = token.LastUsedAt.Local().Format("2006-01-02 15:04")
+			}
+			status := "active"
+			switch {
+			case token.RevokedAt != nil:
+				status = "revoked"
+			case now.After(token.ExpiresAt):
+				status = "expired"
+			}
+
+			table.Append([]string{
+				strconv.Itoa(token.ID),
+				token.Name,
+				environment,
+				tokenAccess(token.ReadOnly),
+				token.CreatedBy.Email,
+				token.ExpiresAt.Local().Format("2006-01-02 15:04"),
+				lastUsed,
+				status,
+			})
+		}
+
+		// Render the table to stdout
+		table.Render()
+	},
+}
+
+// tokenRevokeCmd represents the token revoke command
+var tokenRevokeCmd = &cobra.Command{
+	Use:   "revoke ID",
+	Short: "Revoke a service token",
+	Long:  `Revoke a service token of a project by the ID shown by 'sbx token list'. It stops working immediately.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		projectName := projectFromFlags(cmd)
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid token ID %q\n", args[0])
+			os.Exit(1)
+		}
+
+		db, err := connectStore()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to connect to the database: %v\n", err)
+			os.Exit(1)
+		}
+		defer db.Close()
+
+		// Only authenticated users may proceed
+		user := requireUser(db)
+
+		if err := db.RevokeServiceToken(user, projectName, id); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to revoke service token: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Revoked service token %d of '%s'\n", id, projectName)
+	},
+}
+
+// tokenAccess describes the permissions of a token
+func tokenAccess(readOnly bool) string {
+	if readOnly {
+		return "read-only"
+	}
+	return "read-write"
+}
+
+// tokenScope describes the project and environment a token is limited to
+func tokenScope(project, environment string) string {
+	if environment == "" {
+		return fmt.Sprintf("every environment of '%s'", project)
+	}
+	return fmt.Sprintf("the %s environment of '%s'", environment, project)
+}
+
+func init() {
+	rootCmd.AddCommand(tokenCmd)
+	tokenCmd.AddCommand(tokenCreateCmd)
+	tokenCmd.AddCommand(tokenListCmd)
+	tokenCmd.AddCommand(tokenRevokeCmd)
+
+	// Flags shared by the token commands
+	tokenCmd.PersistentFlags().StringP("project", "p", "", "Project name")
+
+	// Flags for the token create command
+	tokenCreateCmd.Flags().StringP("env", "e", "", "Limit the token to this environment (default all)")
+	tokenCreateCmd.Flags().StringP("name", "n", "", "Name of the token, e.g. the pipeline using it")
+	tokenCreateCmd.Flags().Bool("read-only", false, "Only allow reading secrets")
+	tokenCreateCmd.Flags().String("ttl", "90d", "How long the token stays valid, e.g. 30d or 12h")
+}
@@ -0,0 +1,244 @@
+// Package config reads the .sbx.yaml file that ties a repository to an sbx
+// project:
+//
+//	project: api               project name used when --project is not given
+//	environment: development   environment used when no environment flag is given
+//	include: [services]        directories searched for secret files
+//	exclude: [node_modules]    paths or names skipped while searching
+//	files: ["*.env"]           patterns of secret file names
+//	format: json               output format of grab and secrets
+//
+// The file is looked up from the working directory upwards, stopping at the
+// repository root, and its directory becomes the root that secret locations
+// are relative to. Paths use forward slashes on every platform.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileName is the name of the project configuration file
+const FileName = ".sbx.yaml"
+
+// DefaultFiles are the secret file patterns used when none are configured
+var DefaultFiles = []string{"*.env"}
+
+// Config is the project configuration
+type Config struct {
+	Project     string   `yaml:"project"`
+	Environment string   `yaml:"environment,omitempty"`
+	Include     []string `yaml:"include,omitempty"`
+	Exclude     []string `yaml:"exclude,omitempty"`
+	Files       []string `yaml:"files,omitempty"`
+	Format      string   `yaml:"format,omitempty"`
+
+	// Path is the file the configuration was read from, empty for the defaults
+	Path string `yaml:"-"`
+	// Root is the directory of the file, or the working directory without one
+	Root string `yaml:"-"`
+}
+
+// Find returns the path of the configuration file that applies to dir,
+// looking in dir and its parents up to the repository root. It returns an
+// empty path when there is none.
+func Find(dir string) (string, error) {
+	for {
+		candidate := filepath.Join(dir, FileName)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+
+		// Don't pick up the configuration of an unrelated enclosing directory
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return "", nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// Load reads and validates a configuration file
+func Load(file string) (*Config, error) {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&cfg); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error parsing %s: %v", file, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", file, err)
+	}
+
+	cfg.Path = file
+	cfg.Root = filepath.Dir(file)
+	return &cfg, nil
+}
+
+// Discover loads the configuration that applies to the working directory. Without
+// a configuration file it returns the defaults, rooted at the working directory.
+func Discover() (*Config, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("error getting the current working directory: %v", err)
+	}
+
+	file, err := Find(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error looking for %s: %v", FileName, err)
+	}
+	if file == "" {
+		return &Config{Root: dir}, nil
+	}
+	return Load(file)
+}
+
+// Write stores the configuration in a new file, refusing to replace an
+// existing one unless overwrite is set
+func (c *Config) Write(file string, overwrite bool) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("# sbx project configuration, see 'sbx init --help'\n")
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !overwrite {
+		flags |= os.O_EXCL
+	}
+	f, err := os.OpenFile(file, flags, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// validate checks the paths and patterns of the configuration
+func (c *Config) validate() error {
+	for _, include := range c.Include {
+		clean := path.Clean(include)
+		if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return fmt.Errorf("include path %s must be inside the project", include)
+		}
+	}
+	for _, pattern := range append(append([]string{}, c.Exclude...), c.Files...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %s: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// EnvFiles returns the secret files of the project: the files below the
+// included directories whose names match the file patterns, skipping excluded
+// paths. The paths are relative to Root and sorted.
+func (c *Config) EnvFiles() ([]string, error) {
+	includes := c.Include
+	if len(includes) == 0 {
+		includes = []string{"."}
+	}
+	patterns := c.Files
+	if len(patterns) == 0 {
+		patterns = DefaultFiles
+	}
+
+	seen := make(map[string]bool)
+	var files []string
+	for _, include := range includes {
+		start := filepath.Join(c.Root, filepath.FromSlash(path.Clean(include)))
+		err := filepath.WalkDir(start, func(file string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			rel, err := filepath.Rel(c.Root, file)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+
+			if rel != "." && c.excluded(rel, entry.Name()) {
+				if entry.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !entry.IsDir() && matchesAny(patterns, entry.Name()) && !seen[rel] {
+				seen[rel] = true
+				files = append(files, rel)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error searching %s: %v", include, err)
+		}
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// excluded reports whether a path matches an exclude pattern. Patterns with a
+// slash match the whole path relative to Root, others match any path element.
+func (c *Config) excluded(rel, name string) bool {
+	for _, pattern := range c.Exclude {
+		pattern = strings.TrimSuffix(pattern, "/")
+		if strings.Contains(pattern, "/") {
+			if matched, _ := path.Match(path.Clean(pattern), rel); matched {
+				return true
+			}
+		} else if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAny reports whether name matches one of the patterns
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// AuditAction is the kind of operation an audit event records
+type AuditAction string
+
+const (
+	AuditRead      AuditAction = "read"
+	AuditListKeys  AuditAction = "list-keys"
+	AuditCreate    AuditAction = "create"
+	AuditUpdate    AuditAction = "update"
+	AuditDelete    AuditAction = "delete"
+	AuditRotateKey AuditAction = "rotate-key"
+	AuditHistory   AuditAction = "read-history"
+	AuditRollback  AuditAction = "rollback"
+	AuditPromote   AuditAction = "promote"
+
+	AuditCreateToken AuditAction = "create-token"
+	AuditRevokeToken AuditAction = "revoke-token"
+
+	AuditCreateEnvironment AuditAction = "create-environment"
+	AuditDeleteEnvironment AuditAction = "delete-environment"
+	AuditSetParent         AuditAction = "set-parent"
+)
+
+// AuditEvent is a single entry of the append-only audit log
+type AuditEvent struct {
+	ID          int         `json:"id"`
+	Actor       string      `json:"actor"`
+	Project     string      `json:"project"`
+	Environment string      `json:"environment,omitempty"`
+	Key         string      `json:"key,omitempty"`
+	Action      AuditAction `json:"action"`
+	Hostname    string      `json:"hostname,omitempty"`
+	CreatedAt   time.Time   `json:"created_at"`
+}
+
+// AuditFilter narrows down the events returned by ListAuditEvents. Zero
+// values match everything.
+type AuditFilter struct {
+	Project     string
+	Environment string
+	Actor       string
+	Since       time.Time
+	Until       time.Time
+	Limit       int
+}
+
+// recordAudit appends an event to the audit log. Callers treat a failure to
+// record as a failure of the operation itself, so nothing goes unlogged.
+func recordAudit(db querier, actor User, projectName, environmentType, key string, action AuditAction) error {
+	hostname := auditHostname(actor)
+
+	query := `
+		INSERT INTO audit_events (actor_id, actor, project, environment, key, action, hostname, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := db.Exec(query, actor.ID, actor.Email, projectName, environmentType, key, action, hostname, formatTimestamp(time.Now()))
+	if err != nil {
+		return fmt.Errorf("error recording audit event: %v", err)
+	}
+	return nil
+}
+
+// auditHostname returns the machine an actor is acting from
+func auditHostname(actor User) string {
+	if actor.Hostname != "" {
+		return actor.Hostname
+	}
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// ListAuditEvents returns audit events matching the filter, newest first.
+// Admins may query every project; everyone else needs to be a maintainer of
+// the project they filter on.
+func (db *sqlStore) ListAuditEvents(actor User, filter AuditFilter) ([]AuditEvent, error) {
+	if !actor.Admin {
+		if filter.Project == "" {
+			return nil, fmt.Errorf("%w: a project is required unless you are an admin", ErrPermissionDenied)
+		}
+		if err := authorize(db, actor, filter.Project, "", PermMaintain); err != nil {
+			return nil, err
+		}
+	}
+
+	var conditions []string
+	var args []interface{}
+	if filter.Project != "" {
+		conditions = append(conditions, "project = ?")
+		args = append(args, filter.Project)
+	}
+	if filter.Environment != "" {
+		conditions = append(conditions, "environment = ?")
+		args = append(args, filter.Environment)
+	}
+	if filter.Actor != "" {
+		conditions = append(conditions, "actor = ?")
+		args = append(args, filter.Actor)
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, formatTimestamp(filter.Since))
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "created_at <= ?")
+		args = append(args, formatTimestamp(filter.Until))
+	}
+
+	query := `SELECT id, actor, project, environment, key, action, hostname, created_at FROM audit_events`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY created_at DESC, id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching audit events: %v", err)
+	}
+	defer rows.Close()
+
+	var events []AuditEvent
+	for rows.Next() {
+		var event AuditEvent
+		var createdAt string
+		if err := rows.Scan(&event.ID, &event.Actor, &event.Project, &event.Environment, &event.Key,
+			&event.Action, &event.Hostname, &createdAt); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		event.CreatedAt, err = parseTimestamp(createdAt)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing created_at of audit event %d: %v", event.ID, err)
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
@@ -0,0 +1,207 @@
+package db
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Passwords are hashed with argon2id and stored in the PHC string format:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+const (
+	argonTime    = 3
+	argonMemory  = 64 * 1024
+	argonThreads = 2
+	argonKeyLen  = 32
+	argonSaltLen = 16
+)
+
+// SessionTTL is how long a session created by login stays valid
+const SessionTTL = 12 * time.Hour
+
+// ErrInvalidCredentials is returned when an email/password pair does not match
+var ErrInvalidCredentials = errors.New("invalid email or password")
+
+// ErrInvalidSession is returned when a session token is unknown or expired
+var ErrInvalidSession = errors.New("session is invalid or has expired, please log in again")
+
+// ErrInvalidToken is returned when a service token is unknown, expired or revoked
+var ErrInvalidToken = errors.New("service token is invalid, expired or revoked")
+
+// hashPassword derives an argon2id hash of the password with a random salt
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, argonSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("error generating salt: %v", err)
+	}
+
+	hash := argon2.IDKey([]byte(password), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argonMemory, argonTime, argonThreads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(hash)), nil
+}
+
+// verifyPassword checks a password against an encoded argon2id hash
+func verifyPassword(encoded, password string) (bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false, fmt.Errorf("unsupported password hash format")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, fmt.Errorf("unsupported argon2 version")
+	}
+
+	var memory, iterations uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return false, fmt.Errorf("invalid argon2 parameters: %v", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, fmt.Errorf("invalid salt: %v", err)
+	}
+	hash, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, fmt.Errorf("invalid hash: %v", err)
+	}
+
+	candidate := argon2.IDKey([]byte(password), salt, iterations, memory, threads, uint32(len(hash)))
+	return subtle.ConstantTimeCompare(hash, candidate) == 1, nil
+}
+
+// hashToken returns the hex encoded SHA-256 of a session token. Only the hash is
+// stored so a leaked database does not leak usable sessions.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CountUsers returns the number of registered users
+func (db *sqlStore) CountUsers() (int, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("error counting users: %v", err)
+	}
+	return count, nil
+}
+
+// Authenticate verifies an email and password and returns the matching user.
+// Users registered before passwords were hashed are upgraded to a hash on
+// their first successful login.
+func (db *sqlStore) Authenticate(email, password string) (User, error) {
+	var user User
+	err := db.QueryRow("SELECT id, email, password, admin FROM users WHERE email = ?", email).
+		Scan(&user.ID, &user.Email, &user.Password, &user.Admin)
+	if err == sql.ErrNoRows {
+		return User{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return User{}, fmt.Errorf("error fetching user: %v", err)
+	}
+
+	if !strings.HasPrefix(user.Password, "$argon2id$") {
+		// Legacy plaintext password
+		if subtle.ConstantTimeCompare([]byte(user.Password), []byte(password)) != 1 {
+			return User{}, ErrInvalidCredentials
+		}
+		hashed, err := hashPassword(password)
+		if err != nil {
+			return User{}, err
+		}
+		if _, err := db.Exec("UPDATE users SET password = ? WHERE id = ?", hashed, user.ID); err != nil {
+			return User{}, fmt.Errorf("error upgrading password hash: %v", err)
+		}
+		user.Password = hashed
+		return user, nil
+	}
+
+	ok, err := verifyPassword(user.Password, password)
+	if err != nil {
+		return User{}, fmt.Errorf("error verifying password: %v", err)
+	}
+	if !ok {
+		return User{}, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+// CreateSession starts a new session for the user and returns its token
+func (db *sqlStore) CreateSession(userID int) (string, time.Time, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", time.Time{}, fmt.Errorf("error generating session token: %v", err)
+	}
+	token := hex.EncodeToString(raw)
+	expiresAt := time.Now().Add(SessionTTL).UTC()
+
+	query := `INSERT INTO sessions (user_id, token_hash, expires_at) VALUES (?, ?, ?)`
+	_, err := db.Exec(query, userID, hashToken(token), formatTimestamp(expiresAt))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("error creating session: %v", err)
+	}
+
+	return token, expiresAt, nil
+}
+
+// Login authenticates a user and starts a session, returning the user and the
+// session token
+func (db *sqlStore) Login(email, password string) (User, string, time.Time, error) {
+	user, err := db.Authenticate(email, password)
+	if err != nil {
+		return User{}, "", time.Time{}, err
+	}
+
+	token, expiresAt, err := db.CreateSession(user.ID)
+	if err != nil {
+		return User{}, "", time.Time{}, err
+	}
+	return user, token, expiresAt, nil
+}
+
+// GetSessionUser returns the user owning a valid, unexpired session token.
+// Service tokens are accepted as well, see serviceTokenUser.
+func (db *sqlStore) GetSessionUser(token string) (User, error) {
+	if isServiceToken(token) {
+		return db.serviceTokenUser(token)
+	}
+
+	query := `
+		SELECT u.id, u.email, u.admin
+		FROM sessions s
+		INNER JOIN users u ON s.user_id = u.id
+		WHERE s.token_hash = ? AND s.expires_at > ?`
+
+	var user User
+	err := db.QueryRow(query, hashToken(token), formatTimestamp(time.Now())).Scan(&user.ID, &user.Email, &user.Admin)
+	if err == sql.ErrNoRows {
+		return User{}, ErrInvalidSession
+	}
+	if err != nil {
+		return User{}, fmt.Errorf("error fetching session: %v", err)
+	}
+	return user, nil
+}
+
+// DeleteSession revokes a session token and clears out expired sessions
+func (db *sqlStore) DeleteSession(token string) error {
+	query := `DELETE FROM sessions WHERE token_hash = ? OR expires_at <= ?`
+	_, err := db.Exec(query, hashToken(token), formatTimestamp(time.Now()))
+	if err != nil {
+		return fmt.Errorf("error deleting session: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,426 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// batchSize caps the number of rows written by a single multi-row INSERT so
+// statements stay well below SQLite's bound parameter limit
+const batchSize = 100
+
+// SecretWrite is a secret to create or update as part of a changeset
+type SecretWrite struct {
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	Location string `json:"location"`
+}
+
+// SecretRef identifies a secret within an environment. The same key may be
+// stored in several locations, e.g. PORT in both api/.env and web/.env.
+type SecretRef struct {
+	Key      string `json:"key"`
+	Location string `json:"location"`
+}
+
+// ValidateLocation checks that a secret location is a directory relative to
+// the project root, such as "." or "services/api". Locations decide where grab
+// writes .env files, so they must not lead out of the project.
+func ValidateLocation(location string) error {
+	if location == "" {
+		return invalidf("invalid location: it must not be empty, use '.' for the project root")
+	}
+	if strings.HasPrefix(location, "/") || strings.Contains(location, `\`) {
+		return invalidf("invalid location '%s': use a relative path with '/' separators", location)
+	}
+	for _, segment := range strings.Split(location, "/") {
+		if segment == ".." {
+			return invalidf("invalid location '%s': it must stay inside the project", location)
+		}
+	}
+	return nil
+}
+
+// Changeset is a set of writes to one environment that is applied atomically
+type Changeset struct {
+	Set    []SecretWrite `json:"set,omitempty"`    // Created when missing, updated otherwise
+	Delete []SecretRef   `json:"delete,omitempty"` // Secrets to delete
+	Note   string        `json:"note,omitempty"`   // Recorded with every version the changeset writes
+	Action AuditAction   `json:"action,omitempty"` // Audited for every key instead of create, update or delete when set
+}
+
+// IsEmpty reports whether the changeset has nothing to apply
+func (c Changeset) IsEmpty() bool {
+	return len(c.Set) == 0 && len(c.Delete) == 0
+}
+
+// existingSecret is the current state of a secret row, used while applying a changeset
+type existingSecret struct {
+	id            int
+	location      string
+	deleted       bool
+	latestVersion int
+}
+
+// versionRow is a pending insert into secret_versions
+type versionRow struct {
+	secretID   int
+	version    int
+	value      string
+	keyVersion int
+	location   string
+	deleted    bool
+	note       string
+}
+
+// ApplyChangeset applies every write of the changeset to an environment in a
+// single transaction: either all of it is stored, or none of it is. Existing
+// secrets are fetched in one query, and secrets, versions and audit events are
+// written in batches to keep round trips to the database low.
+func (db *sqlStore) ApplyChangeset(actor User, projectName, environmentType string, changeset Changeset) error {
+	if changeset.IsEmpty() {
+		return nil
+	}
+
+	for _, write := range changeset.Set {
+		if err := ValidateLocation(write.Location); err != nil {
+			return err
+		}
+	}
+	if err := authorize(db, actor, projectName, environmentType, PermWrite); err != nil {
+		return err
+	}
+
+	ring, err := currentProjectKey(db, projectName)
+	if err != nil {
+		return fmt.Errorf("error loading data key: %v", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var environmentID int
+	err = tx.QueryRow(`
+		SELECT e.id
+		FROM environments e
+		INNER JOIN projects p ON e.project_id = p.id
+		WHERE p.name = ? AND e.environment_type = ?`,
+		projectName, environmentType).Scan(&environmentID)
+	if err != nil {
+		return fmt.Errorf("error finding environment ID: %v", err)
+	}
+
+	existing, err := existingSecrets(tx, environmentID)
+	if err != nil {
+		return err
+	}
+
+	now := formatTimestamp(time.Now())
+	var versions []versionRow
+	var audits []auditEntry
+
+	// A key written more than once takes the last value
+	last := make(map[SecretRef]int)
+	for i, write := range changeset.Set {
+		last[SecretRef{Key: write.Key, Location: write.Location}] = i
+	}
+
+	// Create the secrets that don't exist yet, their values are written with
+	// the others once their IDs are known to bind the ciphertexts to
+	var inserts []SecretRef
+	for i, write := range changeset.Set {
+		ref := SecretRef{Key: write.Key, Location: write.Location}
+		if last[ref] != i {
+			continue
+		}
+		if current, exists := existing[ref]; exists {
+			action := AuditUpdate
+			if current.deleted {
+				action = AuditCreate
+			}
+			audits = append(audits, auditEntry{key: write.Key, action: action})
+		} else {
+			inserts = append(inserts, ref)
+			audits = append(audits, auditEntry{key: write.Key, action: AuditCreate})
+		}
+	}
+
+	created, err := insertSecrets(tx, actor, now, inserts)
+	if err != nil {
+		return err
+	}
+	if err := linkSecrets(tx, environmentID, created); err != nil {
+		return err
+	}
+
+	var updates []secretUpdate
+	for i, write := range changeset.Set {
+		ref := SecretRef{Key: write.Key, Location: write.Location}
+		if last[ref] != i {
+			continue
+		}
+		current, exists := existing[ref]
+		if !exists {
+			current = existingSecret{id: created[ref]}
+		}
+
+		version := current.latestVersion + 1
+		ciphertext, keyVersion, err := ring.seal(current.id, version, write.Value)
+		if err != nil {
+			return fmt.Errorf("error encrypting secret %s: %v", write.Key, err)
+		}
+
+		updates = append(updates, secretUpdate{id: current.id, value: ciphertext})
+		versions = append(versions, versionRow{
+			secretID:   current.id,
+			version:    version,
+			value:      ciphertext,
+			keyVersion: keyVersion,
+			location:   write.Location,
+			note:       changeset.Note,
+		})
+		existing[ref] = existingSecret{id: current.id, location: write.Location, latestVersion: version}
+	}
+
+	if err := updateSecrets(tx, actor, now, ring.current, updates); err != nil {
+		return err
+	}
+
+	var deletes []interface{}
+	for _, ref := range changeset.Delete {
+		current, exists := existing[ref]
+		if !exists || current.deleted {
+			continue
+		}
+		deletes = append(deletes, current.id)
+		versions = append(versions, versionRow{secretID: current.id, version: current.latestVersion + 1, location: current.location, deleted: true, note: changeset.Note})
+		audits = append(audits, auditEntry{key: ref.Key, action: AuditDelete})
+		// A ref named twice is only deleted once
+		current.deleted = true
+		current.latestVersion++
+		existing[ref] = current
+	}
+	for start := 0; start < len(deletes); start += batchSize {
+		ids := deletes[start:min(start+batchSize, len(deletes))]
+		query := `UPDATE secrets SET deleted_at = ?, updated_by = ?, updated_at = ? WHERE id IN (` + placeholders(len(ids)) + `)`
+		args := append([]interface{}{now, actor.ID, now}, ids...)
+		if _, err := tx.Exec(query, args...); err != nil {
+			return fmt.Errorf("error deleting secrets: %v", err)
+		}
+	}
+
+	if err := insertVersions(tx, actor, now, versions); err != nil {
+		return err
+	}
+	if changeset.Action != "" {
+		for i := range audits {
+			audits[i].action = changeset.Action
+		}
+	}
+	if err := recordAuditBatch(tx, actor, projectName, environmentType, audits); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing changes: %v", err)
+	}
+	return nil
+}
+
+// existingSecrets returns every secret of an environment, including deleted
+// ones, indexed by key and location
+func existingSecrets(db querier, environmentID int) (map[SecretRef]existingSecret, error) {
+	query := `
+		SELECT s.id, s.key, s.location, s.deleted_at IS NOT NULL,
+			(SELECT COALESCE(MAX(v.version), 0) FROM secret_versions v WHERE v.secret_id = s.id)
+		FROM secrets s
+		INNER JOIN environment_secrets es ON s.id = es.secret_id
+		WHERE es.environment_id = ?
+		ORDER BY s.id`
+
+	rows, err := db.Query(query, environmentID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching existing secrets: %v", err)
+	}
+	defer rows.Close()
+
+	existing := make(map[SecretRef]existingSecret)
+	for rows.Next() {
+		var ref SecretRef
+		var secret existingSecret
+		if err := rows.Scan(&secret.id, &ref.Key, &secret.location, &secret.deleted, &secret.latestVersion); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		ref.Location = secret.location
+		// Prefer the live row if a secret somehow has several
+		if current, seen := existing[ref]; seen && !current.deleted && secret.deleted {
+			continue
+		}
+		existing[ref] = secret
+	}
+
+	return existing, rows.Err()
+}
+
+// secretUpdate is a new value for an existing secret row
+type secretUpdate struct {
+	id    int
+	value string
+}
+
+// updateSecrets stores new values on existing (possibly deleted) secrets,
+// updating a batch of rows with each statement
+func updateSecrets(db querier, actor User, now string, keyVersion int, updates []secretUpdate) error {
+	for start := 0; start < len(updates); start += batchSize {
+		end := min(start+batchSize, len(updates))
+
+		var cases strings.Builder
+		var args, ids []interface{}
+		for _, update := range updates[start:end] {
+			cases.WriteString(" WHEN ? THEN ?")
+			args = append(args, update.id, update.value)
+			ids = append(ids, update.id)
+		}
+		args = append(args, keyVersion, actor.ID, now)
+		args = append(args, ids...)
+
+		query := `
+			UPDATE secrets
+			SET value = CASE id` + cases.String() + ` END,
+				key_version = ?, updated_by = ?, updated_at = ?, deleted_at = NULL
+			WHERE id IN (` + placeholders(len(ids)) + `)`
+		if _, err := db.Exec(query, args...); err != nil {
+			return fmt.Errorf("error updating secrets: %v", err)
+		}
+	}
+	return nil
+}
+
+// insertSecrets creates secrets without a value using multi-row INSERTs and
+// returns their IDs
+func insertSecrets(db querier, actor User, now string, inserts []SecretRef) (map[SecretRef]int, error) {
+	created := make(map[SecretRef]int)
+	for start := 0; start < len(inserts); start += batchSize {
+		end := min(start+batchSize, len(inserts))
+
+		var values []string
+		var args []interface{}
+		for _, insert := range inserts[start:end] {
+			values = append(values, "(?, '', 0, ?, ?, ?, ?)")
+			args = append(args, insert.Key, insert.Location, actor.ID, actor.ID, now)
+		}
+
+		// The order of the returned rows is unspecified, so they are matched up by key and location
+		query := `
+			INSERT INTO secrets (key, value, key_version, location, creator_id, updated_by, updated_at)
+			VALUES ` + strings.Join(values, ", ") + `
+			RETURNING id, key, location`
+		rows, err := db.Query(query, args...)
+		if err != nil {
+			return nil, fmt.Errorf("error creating secrets: %v", err)
+		}
+		for rows.Next() {
+			var id int
+			var ref SecretRef
+			if err := rows.Scan(&id, &ref.Key, &ref.Location); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("error scanning row: %v", err)
+			}
+			created[ref] = id
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("error creating secrets: %v", err)
+		}
+	}
+	return created, nil
+}
+
+// linkSecrets links new secrets to an environment using multi-row INSERTs
+func linkSecrets(db querier, environmentID int, created map[SecretRef]int) error {
+	var ids []int
+	for _, id := range created {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	for start := 0; start < len(ids); start += batchSize {
+		end := min(start+batchSize, len(ids))
+
+		var values []string
+		var args []interface{}
+		for _, id := range ids[start:end] {
+			values = append(values, "(?, ?)")
+			args = append(args, environmentID, id)
+		}
+
+		query := `INSERT INTO environment_secrets (environment_id, secret_id) VALUES ` + strings.Join(values, ", ")
+		if _, err := db.Exec(query, args...); err != nil {
+			return fmt.Errorf("error linking secrets to environment: %v", err)
+		}
+	}
+	return nil
+}
+
+// insertVersions appends version rows using multi-row INSERTs
+func insertVersions(db querier, actor User, now string, versions []versionRow) error {
+	for start := 0; start < len(versions); start += batchSize {
+		end := min(start+batchSize, len(versions))
+
+		var values []string
+		var args []interface{}
+		for _, v := range versions[start:end] {
+			values = append(values, "(?, ?, ?, ?, ?, ?, ?, ?, ?)")
+			args = append(args, v.secretID, v.version, v.value, v.keyVersion, v.location, v.deleted, v.note, actor.ID, now)
+		}
+
+		query := `
+			INSERT INTO secret_versions (secret_id, version, value, key_version, location, deleted, note, created_by, created_at)
+			VALUES ` + strings.Join(values, ", ")
+		if _, err := db.Exec(query, args...); err != nil {
+			return fmt.Errorf("error recording secret versions: %v", err)
+		}
+	}
+	return nil
+}
+
+// auditEntry is a pending audit event for a key
+type auditEntry struct {
+	key    string
+	action AuditAction
+}
+
+// recordAuditBatch appends several audit events using multi-row INSERTs
+func recordAuditBatch(db querier, actor User, projectName, environmentType string, entries []auditEntry) error {
+	hostname := auditHostname(actor)
+	now := formatTimestamp(time.Now())
+
+	for start := 0; start < len(entries); start += batchSize {
+		end := min(start+batchSize, len(entries))
+
+		var values []string
+		var args []interface{}
+		for _, entry := range entries[start:end] {
+			values = append(values, "(?, ?, ?, ?, ?, ?, ?, ?)")
+			args = append(args, actor.ID, actor.Email, projectName, environmentType, entry.key, entry.action, hostname, now)
+		}
+
+		query := `
+			INSERT INTO audit_events (actor_id, actor, project, environment, key, action, hostname, created_at)
+			VALUES ` + strings.Join(values, ", ")
+		if _, err := db.Exec(query, args...); err != nil {
+			return fmt.Errorf("error recording audit events: %v", err)
+		}
+	}
+	return nil
+}
+
+// placeholders returns n comma separated bind parameters
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
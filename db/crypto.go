@@ -0,0 +1,332 @@
+package db
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// Secret values are protected with envelope encryption. Every project owns one
+// or more data keys (one per key version) stored in the project_keys table,
+// each wrapped with the master key from SBX_MASTER_KEY. Values are encrypted
+// with the project's current data key before they are written, so the
+// database only ever holds ciphertext. A key_version of 0 marks a legacy
+// plaintext value written before encryption was introduced.
+//
+// Each ciphertext is bound to where it belongs with additional authenticated
+// data: a value to its project, secret and version, a data key to its project
+// and key version. A ciphertext copied anywhere else fails to decrypt instead
+// of passing for a valid value. Ciphertexts written before this binding carry
+// no "v2:" prefix and are still read; rotating the project key rebinds the
+// current values.
+
+const masterKeyEnv = "SBX_MASTER_KEY"
+
+// masterKey reads and decodes the base64 encoded 32 byte master key
+func masterKey() ([]byte, error) {
+	encoded := getenv(masterKeyEnv)
+	if encoded == "" {
+		return nil, fmt.Errorf("%s is not set (generate one with: openssl rand -base64 32)", masterKeyEnv)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not valid base64: %v", masterKeyEnv, err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("%s must decode to 32 bytes, got %d", masterKeyEnv, len(key))
+	}
+	return key, nil
+}
+
+// boundPrefix marks ciphertexts sealed with additional authenticated data. It
+// can't be mistaken for base64, which has no ':'.
+const boundPrefix = "v2:"
+
+// encrypt seals plaintext with AES-256-GCM, binding it to aad, and returns
+// boundPrefix + base64(nonce || ciphertext)
+func encrypt(key, plaintext, aad []byte) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", fmt.Errorf("error creating cipher: %v", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", fmt.Errorf("error creating GCM: %v", err)
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("error generating nonce: %v", err)
+	}
+
+	sealed := gcm.Seal(nonce, nonce, plaintext, aad)
+	return boundPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt reverses encrypt. Legacy ciphertexts without boundPrefix were sealed
+// without additional data, so aad is not checked for them.
+func decrypt(key []byte, encoded string, aad []byte) ([]byte, error) {
+	encoded, bound := strings.CutPrefix(encoded, boundPrefix)
+	if !bound {
+		aad = nil
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding ciphertext: %v", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating cipher: %v", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("error creating GCM: %v", err)
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext is too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting value: %v", err)
+	}
+	return plaintext, nil
+}
+
+// keyAAD is the additional data binding a wrapped data key to its project and version
+func keyAAD(projectID, version int) []byte {
+	return []byte(fmt.Sprintf("sbx project key %d/%d", projectID, version))
+}
+
+// valueAAD is the additional data binding a value to its project, secret and version
+func valueAAD(projectID, secretID, version int) []byte {
+	return []byte(fmt.Sprintf("sbx secret %d/%d/%d", projectID, secretID, version))
+}
+
+// createProjectKey generates a new data key for the project, wraps it with the
+// master key and stores it under the given version
+func createProjectKey(db querier, projectID, version int) ([]byte, error) {
+	master, err := masterKey()
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, fmt.Errorf("error generating data key: %v", err)
+	}
+
+	wrapped, err := encrypt(master, dataKey, keyAAD(projectID, version))
+	if err != nil {
+		return nil, fmt.Errorf("error wrapping data key: %v", err)
+	}
+
+	query := `INSERT INTO project_keys (project_id, version, wrapped_key) VALUES (?, ?, ?)`
+	_, err = db.Exec(query, projectID, version, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("error storing data key: %v", err)
+	}
+
+	return dataKey, nil
+}
+
+// keyring holds the unwrapped data keys of a project, which seal and open the
+// values of its secrets
+type keyring struct {
+	projectID int
+	keys      map[int][]byte // Data keys by version
+	current   int            // Version of the newest key, 0 when there is none
+}
+
+// projectKeys returns every unwrapped data key of a project
+func projectKeys(db querier, projectName string) (*keyring, error) {
+	master, err := masterKey()
+	if err != nil {
+		return nil, err
+	}
+
+	ring := &keyring{keys: make(map[int][]byte)}
+	err = db.QueryRow("SELECT id FROM projects WHERE name = ?", projectName).Scan(&ring.projectID)
+	if err != nil {
+		return nil, fmt.Errorf("error finding project ID: %v", err)
+	}
+
+	rows, err := db.Query(`SELECT version, wrapped_key FROM project_keys WHERE project_id = ?`, ring.projectID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching data keys: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int
+		var wrapped string
+		if err := rows.Scan(&version, &wrapped); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		dataKey, err := decrypt(master, wrapped, keyAAD(ring.projectID, version))
+		if err != nil {
+			return nil, fmt.Errorf("error unwrapping data key version %d: %v", version, err)
+		}
+		ring.keys[version] = dataKey
+		ring.current = max(ring.current, version)
+	}
+
+	return ring, rows.Err()
+}
+
+// currentProjectKey returns the data keys of a project, ready to seal values
+// with the newest one. Projects created before encryption was introduced get
+// their first key here.
+func currentProjectKey(db querier, projectName string) (*keyring, error) {
+	ring, err := projectKeys(db, projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	if ring.current == 0 {
+		dataKey, err := createProjectKey(db, ring.projectID, 1)
+		if err != nil {
+			return nil, err
+		}
+		ring.keys[1] = dataKey
+		ring.current = 1
+	}
+	return ring, nil
+}
+
+// seal encrypts a value of a secret version with the newest data key and
+// returns it with the key version used
+func (k *keyring) seal(secretID, version int, value string) (string, int, error) {
+	ciphertext, err := encrypt(k.keys[k.current], []byte(value), valueAAD(k.projectID, secretID, version))
+	if err != nil {
+		return "", 0, err
+	}
+	return ciphertext, k.current, nil
+}
+
+// open decrypts a stored value of a secret version using the key of the given version
+func (k *keyring) open(secretID, version int, value string, keyVersion int) (string, error) {
+	if keyVersion == 0 {
+		return value, nil
+	}
+
+	dataKey, ok := k.keys[keyVersion]
+	if !ok {
+		return "", fmt.Errorf("data key version %d not found", keyVersion)
+	}
+
+	plaintext, err := decrypt(dataKey, value, valueAAD(k.projectID, secretID, version))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// nextVersion returns the number of the version a secret's next write records
+func nextVersion(db querier, secretID int) (int, error) {
+	var version int
+	err := db.QueryRow("SELECT COALESCE(MAX(version), 0) + 1 FROM secret_versions WHERE secret_id = ?", secretID).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("error fetching secret version: %v", err)
+	}
+	return version, nil
+}
+
+// valueVersionColumn selects the version the current value of a secret s
+// belongs to: its newest version that is not a deletion, as deleting a secret
+// leaves its value in place
+const valueVersionColumn = `(SELECT COALESCE(MAX(v.version), 0) FROM secret_versions v WHERE v.secret_id = s.id AND NOT v.deleted)`
+
+// RotateProjectKey creates a new data key version for the project and
+// re-encrypts every secret of the project with it in a single transaction.
+// Older key versions are kept because the version history still uses them.
+func (db *sqlStore) RotateProjectKey(actor User, projectName string) (int, error) {
+	if err := authorize(db, actor, projectName, "", PermMaintain); err != nil {
+		return 0, err
+	}
+
+	ring, err := projectKeys(db, projectName)
+	if err != nil {
+		return 0, err
+	}
+	version := ring.current + 1
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	dataKey, err := createProjectKey(tx, ring.projectID, version)
+	if err != nil {
+		return 0, err
+	}
+
+	query := `
+		SELECT s.id, s.value, s.key_version, ` + valueVersionColumn + `
+		FROM secrets s
+		INNER JOIN environment_secrets es ON s.id = es.secret_id
+		INNER JOIN environments e ON es.environment_id = e.id
+		WHERE e.project_id = ?`
+
+	rows, err := tx.Query(query, ring.projectID)
+	if err != nil {
+		return 0, fmt.Errorf("error fetching secrets: %v", err)
+	}
+
+	type storedSecret struct {
+		id           int
+		value        string
+		keyVersion   int
+		valueVersion int
+	}
+	var stored []storedSecret
+	for rows.Next() {
+		var s storedSecret
+		if err := rows.Scan(&s.id, &s.value, &s.keyVersion, &s.valueVersion); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error scanning row: %v", err)
+		}
+		stored = append(stored, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error during rows iteration: %v", err)
+	}
+
+	// Values keep the version they are bound to, only the key changes
+	ring.keys[version] = dataKey
+	ring.current = version
+	for _, s := range stored {
+		plaintext, err := ring.open(s.id, s.valueVersion, s.value, s.keyVersion)
+		if err != nil {
+			return 0, fmt.Errorf("error decrypting secret %d: %v", s.id, err)
+		}
+		ciphertext, _, err := ring.seal(s.id, s.valueVersion, plaintext)
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.Exec(`UPDATE secrets SET value = ?, key_version = ? WHERE id = ?`, ciphertext, version, s.id)
+		if err != nil {
+			return 0, fmt.Errorf("error re-encrypting secret %d: %v", s.id, err)
+		}
+	}
+
+	if err := recordAudit(tx, actor, projectName, "", "", AuditRotateKey); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing key rotation: %v", err)
+	}
+
+	return version, nil
+}
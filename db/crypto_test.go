@@ -0,0 +1,137 @@
+package db
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestEncryptRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte("d"), 32)
+	aad := valueAAD(1, 2, 3)
+
+	ciphertext, err := encrypt(key, []byte("s3cr3t"), aad)
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	if !strings.HasPrefix(ciphertext, boundPrefix) || strings.Contains(ciphertext, "s3cr3t") {
+		t.Errorf("ciphertext = %q", ciphertext)
+	}
+
+	plaintext, err := decrypt(key, ciphertext, aad)
+	if err != nil || string(plaintext) != "s3cr3t" {
+		t.Errorf("decrypt = %q, %v", plaintext, err)
+	}
+
+	// A value only decrypts where it was written
+	for _, other := range [][]byte{valueAAD(9, 2, 3), valueAAD(1, 9, 3), valueAAD(1, 2, 9), keyAAD(1, 2)} {
+		if _, err := decrypt(key, ciphertext, other); err == nil {
+			t.Errorf("decrypt with additional data %q succeeded", other)
+		}
+	}
+	if _, err := decrypt(bytes.Repeat([]byte("x"), 32), ciphertext, aad); err == nil {
+		t.Error("decrypt with another key succeeded")
+	}
+}
+
+// Values written before they were bound carry no prefix and are still read
+func TestDecryptLegacy(t *testing.T) {
+	key := bytes.Repeat([]byte("d"), 32)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		t.Fatal(err)
+	}
+	legacy := base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte("old"), nil))
+
+	plaintext, err := decrypt(key, legacy, valueAAD(1, 2, 3))
+	if err != nil || string(plaintext) != "old" {
+		t.Errorf("decrypt = %q, %v", plaintext, err)
+	}
+}
+
+func TestMasterKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		fails bool
+	}{
+		{name: "valid", value: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("k"), 32))},
+		{name: "unset", value: "", fails: true},
+		{name: "not base64", value: "not base64!", fails: true},
+		{name: "too short", value: base64.StdEncoding.EncodeToString([]byte("short")), fails: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv(masterKeyEnv, test.value)
+			if _, err := masterKey(); (err != nil) != test.fails {
+				t.Errorf("masterKey() error = %v, want failure %v", err, test.fails)
+			}
+		})
+	}
+}
+
+func TestSecretEncryption(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		owner := newTestUser(t, store, "owner@example.com", false)
+		if err := store.CreateProject(owner, "api"); err != nil {
+			t.Fatalf("CreateProject: %v", err)
+		}
+		for key, value := range map[string]string{"A": "one", "B": "two"} {
+			if err := store.CreateSecret(owner, key, value, ".", "api", "development"); err != nil {
+				t.Fatalf("CreateSecret(%s): %v", key, err)
+			}
+		}
+		if err := store.UpdateSecret(owner, "A", "three", ".", "api", "development"); err != nil {
+			t.Fatalf("UpdateSecret: %v", err)
+		}
+
+		// Values are bound to their version, so restoring one re-encrypts it
+		if err := store.RollbackSecret(owner, "A", ".", "api", "development", 1); err != nil {
+			t.Fatalf("RollbackSecret: %v", err)
+		}
+		if _, err := store.RotateProjectKey(owner, "api"); err != nil {
+			t.Fatalf("RotateProjectKey: %v", err)
+		}
+		values := secretValues(t, store, owner, "api", "development")
+		if values["A"] != "one" || values["B"] != "two" {
+			t.Errorf("values after rollback and rotation = %v", values)
+		}
+		history, err := store.SecretHistory(owner, "A", ".", "api", "development")
+		if err != nil {
+			t.Fatalf("SecretHistory: %v", err)
+		}
+		var got []string
+		for _, v := range history {
+			got = append(got, v.Value)
+		}
+		if strings.Join(got, ",") != "one,three,one" {
+			t.Errorf("history = %v, want one, three, one", got)
+		}
+
+		// A ciphertext copied to another secret of the project doesn't pass for its value
+		db := store.(*sqlStore)
+		_, err = db.Exec(`
+			UPDATE secrets
+			SET value = (SELECT value FROM secrets WHERE key = 'A'), key_version = (SELECT key_version FROM secrets WHERE key = 'A')
+			WHERE key = 'B'`)
+		if err != nil {
+			t.Fatalf("copying ciphertext: %v", err)
+		}
+		if _, err := store.GetSecrets(owner, "api", "development"); err == nil {
+			t.Error("GetSecrets succeeded with a ciphertext copied from another secret")
+		}
+	})
+}
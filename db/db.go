@@ -2,62 +2,151 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
+	"time"
 
 	"github.com/joho/godotenv"
-	_ "github.com/tursodatabase/libsql-client-go/libsql"
 )
 
-// ConnectToDB establishes a connection to the database and returns the *sql.DB object.
-func ConnectToDB() (*sql.DB, error) {
-	err := godotenv.Load()
+// querier is implemented by both *sql.DB and *sql.Tx, so internal helpers can
+// run either directly against the database or inside a transaction
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// ConnectToDB connects to the configured database, see OpenDB. It refuses to
+// hand out a connection while schema migrations are pending.
+func ConnectToDB() (Store, error) {
+	db, err := OpenDB()
 	if err != nil {
-		fmt.Println("Error loading .env file")
+		return nil, err
 	}
 
-	// Fetch the database URL and auth token from environment variables
-	dbURL := os.Getenv("TURSO_DATABASE_URL")
-	authToken := os.Getenv("TURSO_AUTH_TOKEN")
+	pending, err := db.PendingMigrations()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if len(pending) > 0 {
+		db.Close()
+		return nil, fmt.Errorf("database schema is out of date (%d pending migrations), run 'sbx db migrate'", len(pending))
+	}
 
-	if dbURL == "" || authToken == "" {
-		fmt.Fprintln(os.Stderr, "Environment variables TURSO_DATABASE_URL or TURSO_AUTH_TOKEN are not set.")
-		os.Exit(1)
+	return db, nil
+}
+
+// ConfigVariables configure the database and the encryption of secrets. They
+// hold credentials, so sbx keeps them from the commands it runs.
+var ConfigVariables = []string{"SBX_DATABASE_URL", "TURSO_DATABASE_URL", "TURSO_AUTH_TOKEN", masterKeyEnv}
+
+// dotenvConfig holds the variables of the .env file read by OpenDB
+var dotenvConfig map[string]string
+
+// getenv returns a configuration variable from the environment, falling back
+// to the .env file read by OpenDB. The file is never loaded into the
+// environment, so the secrets it may hold don't spread to child processes.
+func getenv(name string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
 	}
+	return dotenvConfig[name]
+}
 
-	// Construct the database connection URL
-	url := fmt.Sprintf("%s?authToken=%s", dbURL, authToken)
+// OpenDB connects to the database configured in the environment or a .env
+// file without checking the schema version. SBX_DATABASE_URL selects the
+// backend, see Open; without it the Turso database in TURSO_DATABASE_URL is
+// used, authenticated with TURSO_AUTH_TOKEN.
+func OpenDB() (Store, error) {
+	// Commands write their output to stdout, so problems are only reported on
+	// stderr, and a missing .env is not a problem at all
+	config, err := godotenv.Read()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "Error loading .env file: %v\n", err)
+	}
+	dotenvConfig = config
 
-	// Attempt to connect to the database
-	db, err := sql.Open("libsql", url)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to open db %s: %s\n", url, err)
-		os.Exit(1)
+	if url := getenv("SBX_DATABASE_URL"); url != "" {
+		return Open(url)
 	}
-	// defer db.Close()
 
-	// Ping the database to ensure the connection is successful
-	if err := db.Ping(); err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to connect to the database: %s\n", err)
-		os.Exit(1)
+	// Fetch the database URL and auth token from environment variables
+	dbURL := getenv("TURSO_DATABASE_URL")
+	authToken := getenv("TURSO_AUTH_TOKEN")
+
+	if dbURL == "" || authToken == "" {
+		return nil, fmt.Errorf("no database configured: set SBX_DATABASE_URL, or TURSO_DATABASE_URL and TURSO_AUTH_TOKEN")
 	}
 
-	return db, nil
+	return Open(fmt.Sprintf("%s?authToken=%s", dbURL, authToken))
 }
 
-// CreateUser inserts a new user into the database
-func CreateUser(db *sql.DB, email, password string, admin bool) error {
+// CreateUser inserts a new user into the database with an argon2id hash of the password
+func (db *sqlStore) CreateUser(email, password string, admin bool) error {
+	var existingID int
+	err := db.QueryRow("SELECT id FROM users WHERE email = ?", email).Scan(&existingID)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("error checking for existing user: %v", err)
+	}
+	if existingID != 0 {
+		return invalidf("a user with the email '%s' already exists", email)
+	}
+
+	hashed, err := hashPassword(password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %v", err)
+	}
+
 	query := `INSERT INTO users (email, password, admin) VALUES (?, ?, ?)`
-	_, err := db.Exec(query, email, password, admin)
+	_, err = db.Exec(query, email, hashed, admin)
 	if err != nil {
 		return fmt.Errorf("failed to create user: %v", err)
 	}
-	fmt.Println("User created successfully")
 	return nil
 }
 
-// CreateProject inserts a new project into the database and creates associated environments
-func CreateProject(db *sql.DB, name string) error {
+// ListUsers returns every user, without password hashes. Any logged in user
+// may list users, but service tokens may not.
+func (db *sqlStore) ListUsers(actor User) ([]User, error) {
+	if actor.Token != nil {
+		return nil, fmt.Errorf("%w: service tokens cannot list users", ErrPermissionDenied)
+	}
+
+	rows, err := db.Query("SELECT id, email, admin FROM users ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("error fetching users: %v", err)
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Email, &user.Admin); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// CreateProject inserts a new project into the database and creates associated environments.
+// The creator becomes the project's first admin member. The project, its data key, environments
+// and membership are written in one transaction, so a failure leaves no half created project behind.
+func (db *sqlStore) CreateProject(creator User, name string) error {
+	if creator.Token != nil {
+		return fmt.Errorf("%w: service tokens cannot create projects", ErrPermissionDenied)
+	}
+
+	// The data key can't be wrapped without the master key
+	if _, err := masterKey(); err != nil {
+		return fmt.Errorf("failed to create project data key: %v", err)
+	}
+
 	var existingID int
 	err := db.QueryRow("SELECT id FROM projects WHERE name = ?", name).Scan(&existingID)
 	if err != nil && err != sql.ErrNoRows {
@@ -65,37 +154,52 @@ func CreateProject(db *sql.DB, name string) error {
 	}
 
 	if existingID != 0 {
-		return fmt.Errorf("a project with the name '%s' already exists", name)
+		return invalidf("a project with the name '%s' already exists", name)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
 	}
+	defer tx.Rollback()
 
 	// Insert the project into the projects table
-	query := `INSERT INTO projects (name, active) VALUES (?, 1)`
-	res, err := db.Exec(query, name)
+	var projectID int
+	query := `INSERT INTO projects (name, active) VALUES (?, ?) RETURNING id`
+	err = tx.QueryRow(query, name, true).Scan(&projectID)
 	if err != nil {
 		return fmt.Errorf("failed to create project: %v", err)
 	}
 
-	projectID, err := res.LastInsertId()
-	if err != nil {
-		return fmt.Errorf("failed to retrieve project ID: %v", err)
+	// Generate the first data key used to encrypt this project's secrets
+	if _, err := createProjectKey(tx, projectID, 1); err != nil {
+		return fmt.Errorf("failed to create project data key: %v", err)
 	}
 
 	// Insert the environments associated with this project
-	envTypes := []string{"development", "staging", "production"}
-	for _, envType := range envTypes {
-		envQuery := `INSERT INTO environments (project_id, environment_type) VALUES (?, ?)`
-		_, err := db.Exec(envQuery, projectID, envType)
+	for _, env := range DefaultEnvironments {
+		envQuery := `INSERT INTO environments (project_id, environment_type, protected) VALUES (?, ?, ?)`
+		_, err := tx.Exec(envQuery, projectID, env.Name, env.Protected)
 		if err != nil {
-			return fmt.Errorf("failed to create environment (%s): %v", envType, err)
+			return fmt.Errorf("failed to create environment (%s): %v", env.Name, err)
 		}
 	}
 
-	fmt.Println("Project and associated environments created successfully")
+	// Make the creator an admin of the new project
+	memberQuery := `INSERT INTO project_members (project_id, user_id, role) VALUES (?, ?, ?)`
+	_, err = tx.Exec(memberQuery, projectID, creator.ID, RoleAdmin)
+	if err != nil {
+		return fmt.Errorf("failed to add project admin: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing project: %v", err)
+	}
 	return nil
 }
 
 // ProjectExists checks if a project with the given name already exists
-func ProjectExists(db *sql.DB, name string) (bool, error) {
+func (db *sqlStore) ProjectExists(name string) (bool, error) {
 	var count int
 	err := db.QueryRow("SELECT COUNT(*) FROM projects WHERE name = ?", name).Scan(&count)
 	if err != nil {
@@ -104,18 +208,22 @@ func ProjectExists(db *sql.DB, name string) (bool, error) {
 	return count > 0, nil
 }
 
-// SecretExists checks if a secret with the given key, project, and environment already exists
-func SecretExists(db *sql.DB, key, projectName, environmentType string) (bool, error) {
+// SecretExists checks if a secret with the given key and location already exists in a project environment
+func (db *sqlStore) SecretExists(actor User, key, location, projectName, environmentType string) (bool, error) {
+	if err := authorize(db, actor, projectName, environmentType, PermRead); err != nil {
+		return false, err
+	}
+
 	query := `
 		SELECT COUNT(*)
 		FROM secrets s
 		INNER JOIN environment_secrets es ON s.id = es.secret_id
 		INNER JOIN environments e ON es.environment_id = e.id
 		INNER JOIN projects p ON e.project_id = p.id
-		WHERE s.key = ? AND p.name = ? AND e.environment_type = ?`
+		WHERE s.key = ? AND s.location = ? AND p.name = ? AND e.environment_type = ? AND s.deleted_at IS NULL`
 
 	var count int
-	err := db.QueryRow(query, key, projectName, environmentType).Scan(&count)
+	err := db.QueryRow(query, key, location, projectName, environmentType).Scan(&count)
 	if err != nil {
 		return false, fmt.Errorf("error checking if secret exists: %v", err)
 	}
@@ -123,8 +231,16 @@ func SecretExists(db *sql.DB, key, projectName, environmentType string) (bool, e
 	return count > 0, nil
 }
 
-// CreateSecret inserts a new secret into the database
-func CreateSecret(db *sql.DB, key, value, location, projectName, environmentType string) error {
+// CreateSecret inserts a new secret into the database, recording the creator as its last editor.
+// A previously deleted secret with the same key and location is revived so its history stays in one place.
+func (db *sqlStore) CreateSecret(creator User, key, value, location, projectName, environmentType string) error {
+	if err := ValidateLocation(location); err != nil {
+		return err
+	}
+	if err := authorize(db, creator, projectName, environmentType, PermWrite); err != nil {
+		return err
+	}
+
 	// Find the environment ID
 	var environmentID int
 	err := db.QueryRow(`
@@ -137,59 +253,125 @@ func CreateSecret(db *sql.DB, key, value, location, projectName, environmentType
 		return fmt.Errorf("error finding environment ID: %v", err)
 	}
 
-	// Insert the secret into the secrets table
-	secretQuery := `INSERT INTO secrets (key, value, location, creator_id) VALUES (?, ?, ?, ?)`
-	res, err := db.Exec(secretQuery, key, value, location, 1) // Assuming creator_id = 1 for simplicity
+	// The value is encrypted with the project's current data key
+	ring, err := currentProjectKey(db, projectName)
 	if err != nil {
-		return fmt.Errorf("error creating secret: %v", err)
+		return fmt.Errorf("error encrypting secret: %v", err)
 	}
 
-	secretID, err := res.LastInsertId()
+	// Revive a deleted secret with the same key instead of starting a new history
+	secretID, deleted, err := findSecret(db, key, location, projectName, environmentType)
 	if err != nil {
-		return fmt.Errorf("error getting last insert ID: %v", err)
+		return err
+	}
+	if secretID != 0 && !deleted {
+		return invalidf("secret %s already exists in %s", key, location)
+	}
+
+	if secretID == 0 {
+		// Insert the secret into the secrets table, its value is written
+		// below once its ID is known to bind the ciphertext to
+		secretQuery := `
+			INSERT INTO secrets (key, value, key_version, location, creator_id, updated_by, updated_at)
+			VALUES (?, '', 0, ?, ?, ?, ?)
+			RETURNING id`
+		err := db.QueryRow(secretQuery, key, location, creator.ID, creator.ID, formatTimestamp(time.Now())).Scan(&secretID)
+		if err != nil {
+			return fmt.Errorf("error creating secret: %v", err)
+		}
+
+		// Link the secret to the environment
+		linkQuery := `INSERT INTO environment_secrets (environment_id, secret_id) VALUES (?, ?)`
+		_, err = db.Exec(linkQuery, environmentID, secretID)
+		if err != nil {
+			return fmt.Errorf("error linking secret to environment: %v", err)
+		}
 	}
 
-	// Link the secret to the environment
-	linkQuery := `INSERT INTO environment_secrets (environment_id, secret_id) VALUES (?, ?)`
-	_, err = db.Exec(linkQuery, environmentID, secretID)
+	err = writeSecret(db, ring, creator, secretID, value, location)
 	if err != nil {
-		return fmt.Errorf("error linking secret to environment: %v", err)
+		return fmt.Errorf("error creating secret: %v", err)
+	}
+
+	if err := recordAudit(db, creator, projectName, environmentType, key, AuditCreate); err != nil {
+		return err
 	}
 
 	return nil
 }
 
-// UpdateSecret updates an existing secret in the database
-func UpdateSecret(db *sql.DB, key, value, location, projectName, environmentType string) error {
-	query := `
-		UPDATE secrets
-		SET value = ?, location = ?
-		WHERE id = (
-			SELECT s.id
-			FROM secrets s
-			INNER JOIN environment_secrets es ON s.id = es.secret_id
-			INNER JOIN environments e ON es.environment_id = e.id
-			INNER JOIN projects p ON e.project_id = p.id
-			WHERE s.key = ? AND p.name = ? AND e.environment_type = ?)`
-
-	_, err := db.Exec(query, value, location, key, projectName, environmentType)
+// UpdateSecret updates the value of an existing secret in a location, recording the editor and a new version
+func (db *sqlStore) UpdateSecret(editor User, key, value, location, projectName, environmentType string) error {
+	if err := ValidateLocation(location); err != nil {
+		return err
+	}
+	if err := authorize(db, editor, projectName, environmentType, PermWrite); err != nil {
+		return err
+	}
+
+	// The value is encrypted with the project's current data key
+	ring, err := currentProjectKey(db, projectName)
+	if err != nil {
+		return fmt.Errorf("error encrypting secret: %v", err)
+	}
+
+	secretID, deleted, err := findSecret(db, key, location, projectName, environmentType)
+	if err != nil {
+		return err
+	}
+	if secretID == 0 || deleted {
+		return invalidf("secret %s does not exist in %s", key, location)
+	}
+
+	err = writeSecret(db, ring, editor, secretID, value, location)
 	if err != nil {
 		return fmt.Errorf("error updating secret: %v", err)
 	}
 
-	fmt.Println("Secret updated successfully")
+	if err := recordAudit(db, editor, projectName, environmentType, key, AuditUpdate); err != nil {
+		return err
+	}
+
 	return nil
 }
 
 // GetAllSecretsKeys returns all keys for a given project and environment
-func GetAllSecretsKeys(db *sql.DB, projectName, environmentType string) ([]string, error) {
+func (db *sqlStore) GetAllSecretsKeys(actor User, projectName, environmentType string) ([]string, error) {
+	if err := authorize(db, actor, projectName, environmentType, PermRead); err != nil {
+		return nil, err
+	}
+
+	keys, err := environmentKeys(db, projectName, environmentType)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := recordAudit(db, actor, projectName, environmentType, "", AuditListKeys); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// CompleteKeys returns the keys of an environment for completing them on the
+// command line. Unlike GetAllSecretsKeys it records no audit event, as a
+// shell asks for them on every press of Tab.
+func (db *sqlStore) CompleteKeys(actor User, projectName, environmentType string) ([]string, error) {
+	if err := authorize(db, actor, projectName, environmentType, PermRead); err != nil {
+		return nil, err
+	}
+	return environmentKeys(db, projectName, environmentType)
+}
+
+// environmentKeys returns the keys of the live secrets of an environment
+func environmentKeys(db querier, projectName, environmentType string) ([]string, error) {
 	query := `
 		SELECT s.key
 		FROM secrets s
 		INNER JOIN environment_secrets es ON s.id = es.secret_id
 		INNER JOIN environments e ON es.environment_id = e.id
 		INNER JOIN projects p ON e.project_id = p.id
-		WHERE p.name = ? AND e.environment_type = ?`
+		WHERE p.name = ? AND e.environment_type = ? AND s.deleted_at IS NULL`
 
 	rows, err := db.Query(query, projectName, environmentType)
 	if err != nil {
@@ -206,40 +388,98 @@ func GetAllSecretsKeys(db *sql.DB, projectName, environmentType string) ([]strin
 		keys = append(keys, key)
 	}
 
-	return keys, nil
+	return keys, rows.Err()
 }
 
-// DeleteSecret deletes a secret from the database
-func DeleteSecret(db *sql.DB, key, projectName, environmentType string) error {
-	query := `
-		DELETE FROM secrets
-		WHERE id = (
-			SELECT s.id
-			FROM secrets s
-			INNER JOIN environment_secrets es ON s.id = es.secret_id
-			INNER JOIN environments e ON es.environment_id = e.id
-			INNER JOIN projects p ON e.project_id = p.id
-			WHERE s.key = ? AND p.name = ? AND e.environment_type = ?)`
-
-	_, err := db.Exec(query, key, projectName, environmentType)
+// DeleteSecret deletes the secret stored for a key in a location. The row is only marked as deleted and a
+// deletion version is recorded, so the secret can be rolled back later.
+func (db *sqlStore) DeleteSecret(actor User, key, location, projectName, environmentType string) error {
+	if err := authorize(db, actor, projectName, environmentType, PermWrite); err != nil {
+		return err
+	}
+
+	secretID, deleted, err := findSecret(db, key, location, projectName, environmentType)
 	if err != nil {
+		return err
+	}
+	if secretID == 0 || deleted {
+		return nil
+	}
+
+	if err := markSecretDeleted(db, actor, secretID); err != nil {
 		return fmt.Errorf("error deleting secret: %v", err)
 	}
 
+	if err := recordAudit(db, actor, projectName, environmentType, key, AuditDelete); err != nil {
+		return err
+	}
+
 	return nil
 }
 
-// GetSecrets returns all secrets for a given project and environment
-func GetSecrets(db *sql.DB, projectName, environmentType string) ([]Secret, error) {
+// GetSecrets returns the decrypted secrets of an environment, including the
+// secrets it inherits from its parent environments. A key defined in several
+// layers, in the same location, takes the value of the nearest one, and
+// Source names that layer.
+// The actor needs read access to every environment along the way: inheriting
+// never grants access to an environment the actor couldn't read directly.
+func (db *sqlStore) GetSecrets(actor User, projectName, environmentType string) ([]Secret, error) {
+	if err := authorize(db, actor, projectName, environmentType, PermRead); err != nil {
+		return nil, err
+	}
+
+	chain, err := environmentChain(db, projectName, environmentType)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorizeChain(db, actor, projectName, environmentType, chain[1:]); err != nil {
+		return nil, err
+	}
+
+	ring, err := projectKeys(db, projectName)
+	if err != nil {
+		return nil, fmt.Errorf("error loading data keys: %v", err)
+	}
+
+	// Overlay the layers from the most distant ancestor down to the environment itself
+	var secrets []Secret
+	index := make(map[SecretRef]int)
+	for i := len(chain) - 1; i >= 0; i-- {
+		layer, err := environmentSecrets(db, ring, chain[i])
+		if err != nil {
+			return nil, err
+		}
+		for _, secret := range layer {
+			ref := SecretRef{Key: secret.Key, Location: secret.Location}
+			if position, exists := index[ref]; exists {
+				secrets[position] = secret
+				continue
+			}
+			index[ref] = len(secrets)
+			secrets = append(secrets, secret)
+		}
+	}
+
+	if err := recordAudit(db, actor, projectName, environmentType, "", AuditRead); err != nil {
+		return nil, err
+	}
+
+	return secrets, nil
+}
+
+// environmentSecrets returns the decrypted secrets defined directly in an environment
+func environmentSecrets(db querier, ring *keyring, env Environment) ([]Secret, error) {
 	query := `
-		SELECT s.key, s.value, s.location
+		SELECT s.id, s.key, s.value, s.key_version, ` + valueVersionColumn + `, s.location,
+			c.id, c.email, ub.id, ub.email, s.updated_at
 		FROM secrets s
 		INNER JOIN environment_secrets es ON s.id = es.secret_id
-		INNER JOIN environments e ON es.environment_id = e.id
-		INNER JOIN projects p ON e.project_id = p.id
-		WHERE p.name = ? AND e.environment_type = ?`
+		LEFT JOIN users c ON s.creator_id = c.id
+		LEFT JOIN users ub ON s.updated_by = ub.id
+		WHERE es.environment_id = ? AND s.deleted_at IS NULL
+		ORDER BY s.id`
 
-	rows, err := db.Query(query, projectName, environmentType)
+	rows, err := db.Query(query, env.ID)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching secrets: %v", err)
 	}
@@ -248,11 +488,65 @@ func GetSecrets(db *sql.DB, projectName, environmentType string) ([]Secret, erro
 	var secrets []Secret
 	for rows.Next() {
 		var secret Secret
-		if err := rows.Scan(&secret.Key, &secret.Value, &secret.Location); err != nil {
+		var creatorID, updatedByID sql.NullInt64
+		var creatorEmail, updatedByEmail, updatedAt sql.NullString
+		var valueVersion int
+		if err := rows.Scan(&secret.ID, &secret.Key, &secret.Value, &secret.KeyVersion, &valueVersion, &secret.Location,
+			&creatorID, &creatorEmail, &updatedByID, &updatedByEmail, &updatedAt); err != nil {
 			return nil, fmt.Errorf("error scanning row: %v", err)
 		}
+		secret.Creator = User{ID: int(creatorID.Int64), Email: creatorEmail.String}
+		secret.UpdatedBy = User{ID: int(updatedByID.Int64), Email: updatedByEmail.String}
+		secret.Source = env.Name
+		if updatedAt.Valid {
+			secret.UpdatedAt, err = parseTimestamp(updatedAt.String)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing updated_at of secret %s: %v", secret.Key, err)
+			}
+		}
+		secret.Value, err = ring.open(secret.ID, valueVersion, secret.Value, secret.KeyVersion)
+		if err != nil {
+			return nil, fmt.Errorf("error decrypting secret %s: %v", secret.Key, err)
+		}
 		secrets = append(secrets, secret)
 	}
 
-	return secrets, nil
+	return secrets, rows.Err()
+}
+
+// ListProjects returns the projects visible to the actor: every project for
+// admins, the project of a service token, otherwise the projects the actor is
+// a member of
+func (db *sqlStore) ListProjects(actor User) ([]Project, error) {
+	query := `SELECT id, name, active FROM projects ORDER BY name`
+	var args []interface{}
+	if actor.Token != nil {
+		query = `SELECT id, name, active FROM projects WHERE name = ?`
+		args = append(args, actor.Token.Project)
+	} else if !actor.Admin {
+		query = `
+			SELECT p.id, p.name, p.active
+			FROM projects p
+			INNER JOIN project_members m ON m.project_id = p.id
+			WHERE m.user_id = ?
+			ORDER BY p.name`
+		args = append(args, actor.ID)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching projects: %v", err)
+	}
+	defer rows.Close()
+
+	var projects []Project
+	for rows.Next() {
+		var project Project
+		if err := rows.Scan(&project.ID, &project.Name, &project.Active); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		projects = append(projects, project)
+	}
+
+	return projects, rows.Err()
 }
@@ -0,0 +1,354 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// Every project starts with DefaultEnvironments, and any number of further
+// environments (preview, qa, one per customer, ...) can be added to it. The
+// environment name is what commands and memberships refer to.
+//
+// An environment may inherit from a parent environment: reading its secrets
+// returns the parent's secrets, recursively, overlaid with its own. Writes
+// always go to the environment itself, so a key written to a child overrides
+// the inherited value without touching the parent.
+
+// ErrEnvironmentNotFound is returned when a project has no environment with a given name
+var ErrEnvironmentNotFound = errors.New("environment not found")
+
+var environmentNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ValidateEnvironmentName checks that a name is usable as an environment name
+func ValidateEnvironmentName(name string) error {
+	if len(name) > 64 || !environmentNamePattern.MatchString(name) {
+		return invalidf("invalid environment name '%s': use lowercase letters, digits, '-' and '_'", name)
+	}
+	return nil
+}
+
+// GetEnvironment returns an environment of a project by name
+func (db *sqlStore) GetEnvironment(projectName, name string) (Environment, error) {
+	query := `
+		SELECT e.id, e.environment_type, e.protected, COALESCE(pe.environment_type, '')
+		FROM environments e
+		INNER JOIN projects p ON e.project_id = p.id
+		LEFT JOIN environments pe ON e.parent_id = pe.id
+		WHERE p.name = ? AND e.environment_type = ?`
+
+	var env Environment
+	err := db.QueryRow(query, projectName, name).Scan(&env.ID, &env.Name, &env.Protected, &env.Parent)
+	if err == sql.ErrNoRows {
+		return Environment{}, fmt.Errorf("%w: project '%s' has no '%s' environment", ErrEnvironmentNotFound, projectName, name)
+	}
+	if err != nil {
+		return Environment{}, fmt.Errorf("error fetching environment: %v", err)
+	}
+	return env, nil
+}
+
+// ListEnvironments returns the environments of a project ordered by name
+func (db *sqlStore) ListEnvironments(actor User, projectName string) ([]Environment, error) {
+	if err := authorize(db, actor, projectName, "", PermRead); err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT e.id, e.environment_type, e.protected, COALESCE(pe.environment_type, '')
+		FROM environments e
+		INNER JOIN projects p ON e.project_id = p.id
+		LEFT JOIN environments pe ON e.parent_id = pe.id
+		WHERE p.name = ?
+		ORDER BY e.environment_type`
+
+	rows, err := db.Query(query, projectName)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching environments: %v", err)
+	}
+	defer rows.Close()
+
+	var environments []Environment
+	for rows.Next() {
+		var env Environment
+		if err := rows.Scan(&env.ID, &env.Name, &env.Protected, &env.Parent); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		environments = append(environments, env)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+
+	return environments, nil
+}
+
+// CreateEnvironment adds an environment to a project. Changes to a protected
+// environment must be confirmed before they are written. When env.Parent is
+// set, the new environment inherits the secrets of that environment, which
+// requires read access to it and everything it inherits in turn. Members
+// restricted to some environments cannot create new ones, as the restriction
+// would keep them out of the environment they create.
+func (db *sqlStore) CreateEnvironment(actor User, projectName string, env Environment) error {
+	if err := ValidateEnvironmentName(env.Name); err != nil {
+		return err
+	}
+	if err := authorize(db, actor, projectName, env.Name, PermMaintain); err != nil {
+		return err
+	}
+
+	var projectID int
+	err := db.QueryRow("SELECT id FROM projects WHERE name = ?", projectName).Scan(&projectID)
+	if err != nil {
+		return fmt.Errorf("error finding project ID: %v", err)
+	}
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM environments WHERE project_id = ? AND environment_type = ?", projectID, env.Name).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("error checking for existing environment: %v", err)
+	}
+	if count > 0 {
+		return invalidf("project '%s' already has a '%s' environment", projectName, env.Name)
+	}
+
+	var parentID sql.NullInt64
+	if env.Parent != "" {
+		chain, err := environmentChain(db, projectName, env.Parent)
+		if err != nil {
+			return err
+		}
+		if err := authorizeChain(db, actor, projectName, env.Name, chain); err != nil {
+			return err
+		}
+		parentID = sql.NullInt64{Int64: int64(chain[0].ID), Valid: true}
+	}
+
+	query := `INSERT INTO environments (project_id, environment_type, protected, parent_id) VALUES (?, ?, ?, ?)`
+	if _, err := db.Exec(query, projectID, env.Name, env.Protected, parentID); err != nil {
+		return fmt.Errorf("error creating environment: %v", err)
+	}
+
+	return recordAudit(db, actor, projectName, env.Name, "", AuditCreateEnvironment)
+}
+
+// SetEnvironmentParent makes an environment inherit from another one, or stop
+// inheriting when parentName is empty. The parent decides what the environment
+// holds, so this requires access to the environment itself, and like
+// CreateEnvironment, read access to the new parent and everything it inherits.
+func (db *sqlStore) SetEnvironmentParent(actor User, projectName, name, parentName string) error {
+	if err := authorize(db, actor, projectName, name, PermMaintain); err != nil {
+		return err
+	}
+
+	env, err := db.GetEnvironment(projectName, name)
+	if err != nil {
+		return err
+	}
+
+	var parentID sql.NullInt64
+	if parentName != "" {
+		chain, err := environmentChain(db, projectName, parentName)
+		if err != nil {
+			return err
+		}
+		for _, ancestor := range chain {
+			if ancestor.ID == env.ID {
+				return invalidf("%s cannot inherit from %s: %s already inherits from %s", name, parentName, parentName, name)
+			}
+		}
+		if err := authorizeChain(db, actor, projectName, name, chain); err != nil {
+			return err
+		}
+		parentID = sql.NullInt64{Int64: int64(chain[0].ID), Valid: true}
+	}
+
+	if _, err := db.Exec("UPDATE environments SET parent_id = ? WHERE id = ?", parentID, env.ID); err != nil {
+		return fmt.Errorf("error updating environment: %v", err)
+	}
+
+	return recordAudit(db, actor, projectName, name, "", AuditSetParent)
+}
+
+// authorizeChain checks that the actor may read every environment that the
+// named environment inherits from, given as a chain of ancestors
+func authorizeChain(db querier, actor User, projectName, name string, ancestors []Environment) error {
+	for _, ancestor := range ancestors {
+		if err := authorize(db, actor, projectName, ancestor.Name, PermRead); err != nil {
+			return fmt.Errorf("the %s environment inherits from %s: %w", name, ancestor.Name, err)
+		}
+	}
+	return nil
+}
+
+// environmentChain returns an environment followed by its ancestors, nearest first
+func environmentChain(db querier, projectName, name string) ([]Environment, error) {
+	query := `
+		SELECT e.id, e.environment_type, e.protected, e.parent_id
+		FROM environments e
+		INNER JOIN projects p ON e.project_id = p.id
+		WHERE p.name = ?`
+
+	rows, err := db.Query(query, projectName)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching environments: %v", err)
+	}
+	defer rows.Close()
+
+	byID := make(map[int]Environment)
+	parents := make(map[int]int)
+	var current int
+	for rows.Next() {
+		var env Environment
+		var parentID sql.NullInt64
+		if err := rows.Scan(&env.ID, &env.Name, &env.Protected, &parentID); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		byID[env.ID] = env
+		if parentID.Valid {
+			parents[env.ID] = int(parentID.Int64)
+		}
+		if env.Name == name {
+			current = env.ID
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+
+	if current == 0 {
+		return nil, fmt.Errorf("%w: project '%s' has no '%s' environment", ErrEnvironmentNotFound, projectName, name)
+	}
+
+	var chain []Environment
+	visited := make(map[int]bool)
+	for id := current; id != 0; id = parents[id] {
+		if visited[id] {
+			return nil, fmt.Errorf("environment %s has a cyclic parent chain", name)
+		}
+		visited[id] = true
+
+		env := byID[id]
+		env.Parent = byID[parents[id]].Name
+		chain = append(chain, env)
+	}
+	return chain, nil
+}
+
+// DeleteEnvironment removes an environment from a project. An environment that
+// still holds secrets is only deleted when force is set; its secrets are then
+// deleted along with it. Their rows and versions stay in the database, but
+// without the environment linking them to the project their history can't be
+// shown or rolled back anymore.
+func (db *sqlStore) DeleteEnvironment(actor User, projectName, name string, force bool) error {
+	if err := authorize(db, actor, projectName, name, PermAdmin); err != nil {
+		return err
+	}
+
+	env, err := db.GetEnvironment(projectName, name)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var secretCount int
+	err = tx.QueryRow(`
+		SELECT COUNT(*)
+		FROM secrets s
+		INNER JOIN environment_secrets es ON s.id = es.secret_id
+		WHERE es.environment_id = ? AND s.deleted_at IS NULL`,
+		env.ID).Scan(&secretCount)
+	if err != nil {
+		return fmt.Errorf("error counting secrets: %v", err)
+	}
+	if secretCount > 0 && !force {
+		return invalidf("the %s environment still has %d secrets, use --force to delete it anyway", name, secretCount)
+	}
+
+	var child string
+	err = tx.QueryRow("SELECT environment_type FROM environments WHERE parent_id = ? LIMIT 1", env.ID).Scan(&child)
+	if err == nil {
+		return invalidf("the %s environment inherits from %s, change its parent before deleting it", child, name)
+	}
+	if err != sql.ErrNoRows {
+		return fmt.Errorf("error checking child environments: %v", err)
+	}
+
+	// A member restricted to this environment alone would be left without any
+	// restriction, which grants access to every environment
+	var email string
+	err = tx.QueryRow(`
+		SELECT u.email
+		FROM member_environments me
+		INNER JOIN project_members m ON me.member_id = m.id
+		INNER JOIN users u ON m.user_id = u.id
+		WHERE me.environment_id = ?
+		AND (SELECT COUNT(*) FROM member_environments other WHERE other.member_id = me.member_id) = 1
+		LIMIT 1`,
+		env.ID).Scan(&email)
+	if err == nil {
+		return invalidf("%s can only access the %s environment, change their access before deleting it", email, name)
+	}
+	if err != sql.ErrNoRows {
+		return fmt.Errorf("error checking member environments: %v", err)
+	}
+
+	// Delete the remaining secrets the way DeleteSecret does, with a deletion
+	// version each, so the rows left behind don't end in a live value
+	rows, err := tx.Query(`
+		SELECT s.id
+		FROM secrets s
+		INNER JOIN environment_secrets es ON s.id = es.secret_id
+		WHERE es.environment_id = ? AND s.deleted_at IS NULL`,
+		env.ID)
+	if err != nil {
+		return fmt.Errorf("error fetching secrets: %v", err)
+	}
+	var secretIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning row: %v", err)
+		}
+		secretIDs = append(secretIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error during rows iteration: %v", err)
+	}
+	for _, id := range secretIDs {
+		if err := markSecretDeleted(tx, actor, id); err != nil {
+			return fmt.Errorf("error deleting secret: %v", err)
+		}
+	}
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`DELETE FROM environment_secrets WHERE environment_id = ?`, []interface{}{env.ID}},
+		{`DELETE FROM member_environments WHERE environment_id = ?`, []interface{}{env.ID}},
+		{`DELETE FROM environments WHERE id = ?`, []interface{}{env.ID}},
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement.query, statement.args...); err != nil {
+			return fmt.Errorf("error deleting environment: %v", err)
+		}
+	}
+
+	if err := recordAudit(tx, actor, projectName, name, "", AuditDeleteEnvironment); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing changes: %v", err)
+	}
+	return nil
+}
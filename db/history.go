@@ -0,0 +1,386 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Secrets are never overwritten or removed in place. Every write appends an
+// immutable row to secret_versions, and deleting a secret only sets its
+// deleted_at and appends a deletion version, so any earlier state of a secret
+// or a whole environment can be restored.
+
+// findSecret looks up a secret by key and location, including deleted ones.
+// An empty location matches the key in any location, as long as it has only
+// ever been stored in one. It returns an ID of 0 when the key has never
+// existed in the environment.
+func findSecret(db querier, key, location, projectName, environmentType string) (int, bool, error) {
+	query := `
+		SELECT s.id, s.location, s.deleted_at IS NOT NULL
+		FROM secrets s
+		INNER JOIN environment_secrets es ON s.id = es.secret_id
+		INNER JOIN environments e ON es.environment_id = e.id
+		INNER JOIN projects p ON e.project_id = p.id
+		WHERE s.key = ? AND p.name = ? AND e.environment_type = ? AND (? = '' OR s.location = ?)
+		ORDER BY s.deleted_at IS NULL DESC, s.id DESC`
+
+	rows, err := db.Query(query, key, projectName, environmentType, location, location)
+	if err != nil {
+		return 0, false, fmt.Errorf("error finding secret: %v", err)
+	}
+	defer rows.Close()
+
+	// The first row of each location is the one to use: live rows come first
+	secretID, deleted := 0, false
+	var locations []string
+	for rows.Next() {
+		var id int
+		var rowLocation string
+		var rowDeleted bool
+		if err := rows.Scan(&id, &rowLocation, &rowDeleted); err != nil {
+			return 0, false, fmt.Errorf("error scanning row: %v", err)
+		}
+		if slices.Contains(locations, rowLocation) {
+			continue
+		}
+		if secretID == 0 {
+			secretID, deleted = id, rowDeleted
+		}
+		locations = append(locations, rowLocation)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, false, fmt.Errorf("error finding secret: %v", err)
+	}
+
+	if len(locations) > 1 {
+		sort.Strings(locations)
+		return 0, false, invalidf("secret %s exists in several locations (%s), a location is required", key, strings.Join(locations, ", "))
+	}
+	return secretID, deleted, nil
+}
+
+// addSecretVersion appends a version to a secret's history
+func addSecretVersion(db querier, actor User, secretID, version int, ciphertext string, keyVersion int, location string, deleted bool) error {
+	query := `
+		INSERT INTO secret_versions (secret_id, version, value, key_version, location, deleted, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := db.Exec(query, secretID, version, ciphertext, keyVersion, location, deleted, actor.ID, formatTimestamp(time.Now()))
+	if err != nil {
+		return fmt.Errorf("error recording secret version: %v", err)
+	}
+	return nil
+}
+
+// writeSecret encrypts a new value for an existing (possibly deleted) secret,
+// stores it and records it as the secret's next version
+func writeSecret(db querier, ring *keyring, actor User, secretID int, value, location string) error {
+	version, err := nextVersion(db, secretID)
+	if err != nil {
+		return err
+	}
+	ciphertext, keyVersion, err := ring.seal(secretID, version, value)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE secrets
+		SET value = ?, key_version = ?, location = ?, updated_by = ?, updated_at = ?, deleted_at = NULL
+		WHERE id = ?`
+
+	_, err = db.Exec(query, ciphertext, keyVersion, location, actor.ID, formatTimestamp(time.Now()), secretID)
+	if err != nil {
+		return err
+	}
+
+	return addSecretVersion(db, actor, secretID, version, ciphertext, keyVersion, location, false)
+}
+
+// markSecretDeleted soft-deletes a secret and records the deletion as a version
+func markSecretDeleted(db querier, actor User, secretID int) error {
+	now := formatTimestamp(time.Now())
+
+	var location string
+	err := db.QueryRow("SELECT location FROM secrets WHERE id = ?", secretID).Scan(&location)
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE secrets SET deleted_at = ?, updated_by = ?, updated_at = ? WHERE id = ?`
+	if _, err := db.Exec(query, now, actor.ID, now, secretID); err != nil {
+		return err
+	}
+
+	version, err := nextVersion(db, secretID)
+	if err != nil {
+		return err
+	}
+	return addSecretVersion(db, actor, secretID, version, "", 0, location, true)
+}
+
+// storedVersion is a secret version as stored, with its value still encrypted
+type storedVersion struct {
+	version    int
+	value      string
+	keyVersion int
+	location   string
+	deleted    bool
+}
+
+// getSecretVersion loads a specific version of a secret. It returns nil when
+// the version does not exist.
+func getSecretVersion(db querier, secretID, version int) (*storedVersion, error) {
+	query := `
+		SELECT version, value, key_version, location, deleted
+		FROM secret_versions
+		WHERE secret_id = ? AND version = ?`
+
+	var v storedVersion
+	err := db.QueryRow(query, secretID, version).Scan(&v.version, &v.value, &v.keyVersion, &v.location, &v.deleted)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching secret version: %v", err)
+	}
+	return &v, nil
+}
+
+// restoreVersion makes a stored version the current state of a secret. Its
+// value is re-encrypted, as a ciphertext is bound to the version it was written as.
+func restoreVersion(db querier, ring *keyring, actor User, secretID int, currentlyDeleted bool, v *storedVersion) error {
+	if v.deleted {
+		if currentlyDeleted {
+			return nil
+		}
+		return markSecretDeleted(db, actor, secretID)
+	}
+
+	value, err := ring.open(secretID, v.version, v.value, v.keyVersion)
+	if err != nil {
+		return fmt.Errorf("error decrypting version %d: %v", v.version, err)
+	}
+	return writeSecret(db, ring, actor, secretID, value, v.location)
+}
+
+// SecretHistory returns every version of a secret, oldest first, with values
+// decrypted. The location may be left empty when the key is only stored in one.
+func (db *sqlStore) SecretHistory(actor User, key, location, projectName, environmentType string) ([]SecretVersion, error) {
+	if err := authorize(db, actor, projectName, environmentType, PermRead); err != nil {
+		return nil, err
+	}
+
+	secretID, _, err := findSecret(db, key, location, projectName, environmentType)
+	if err != nil {
+		return nil, err
+	}
+	if secretID == 0 {
+		return nil, invalidf("secret %s does not exist in %s", key, environmentType)
+	}
+
+	ring, err := projectKeys(db, projectName)
+	if err != nil {
+		return nil, fmt.Errorf("error loading data keys: %v", err)
+	}
+
+	query := `
+		SELECT v.version, v.value, v.key_version, v.location, v.deleted, v.note, u.id, u.email, v.created_at
+		FROM secret_versions v
+		LEFT JOIN users u ON v.created_by = u.id
+		WHERE v.secret_id = ?
+		ORDER BY v.version`
+
+	rows, err := db.Query(query, secretID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching secret history: %v", err)
+	}
+	defer rows.Close()
+
+	var versions []SecretVersion
+	for rows.Next() {
+		var v SecretVersion
+		var userID sql.NullInt64
+		var email sql.NullString
+		var createdAt string
+		if err := rows.Scan(&v.Version, &v.Value, &v.KeyVersion, &v.Location, &v.Deleted, &v.Note, &userID, &email, &createdAt); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		v.CreatedBy = User{ID: int(userID.Int64), Email: email.String}
+		v.CreatedAt, err = parseTimestamp(createdAt)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing created_at of version %d: %v", v.Version, err)
+		}
+		if !v.Deleted {
+			v.Value, err = ring.open(secretID, v.Version, v.Value, v.KeyVersion)
+			if err != nil {
+				return nil, fmt.Errorf("error decrypting version %d: %v", v.Version, err)
+			}
+		}
+		versions = append(versions, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+
+	if err := recordAudit(db, actor, projectName, environmentType, key, AuditHistory); err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+// RollbackSecret restores a secret to the state it had in the given version.
+// The restore is itself recorded as a new version, in the same transaction as
+// the secret and its audit event. The location may be left empty when the key
+// is only stored in one.
+func (db *sqlStore) RollbackSecret(actor User, key, location, projectName, environmentType string, version int) error {
+	if err := authorize(db, actor, projectName, environmentType, PermWrite); err != nil {
+		return err
+	}
+
+	ring, err := currentProjectKey(db, projectName)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	secretID, deleted, err := findSecret(tx, key, location, projectName, environmentType)
+	if err != nil {
+		return err
+	}
+	if secretID == 0 {
+		return invalidf("secret %s does not exist in %s", key, environmentType)
+	}
+
+	target, err := getSecretVersion(tx, secretID, version)
+	if err != nil {
+		return err
+	}
+	if target == nil {
+		return invalidf("secret %s has no version %d", key, version)
+	}
+
+	if err := restoreVersion(tx, ring, actor, secretID, deleted, target); err != nil {
+		return fmt.Errorf("error restoring version %d of %s: %v", version, key, err)
+	}
+
+	if err := recordAudit(tx, actor, projectName, environmentType, key, AuditRollback); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing rollback: %v", err)
+	}
+	return nil
+}
+
+// RollbackEnvironment restores every secret of an environment to the state it
+// had at the given time: later changes are reverted, secrets deleted since are
+// restored and secrets created since are deleted. The whole rollback runs in
+// one transaction. It returns the secrets it changed.
+func (db *sqlStore) RollbackEnvironment(actor User, projectName, environmentType string, at time.Time) ([]SecretRef, error) {
+	if err := authorize(db, actor, projectName, environmentType, PermWrite); err != nil {
+		return nil, err
+	}
+
+	ring, err := currentProjectKey(db, projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT s.id, s.key, s.location, s.deleted_at IS NOT NULL,
+			(SELECT MAX(v.version) FROM secret_versions v WHERE v.secret_id = s.id)
+		FROM secrets s
+		INNER JOIN environment_secrets es ON s.id = es.secret_id
+		INNER JOIN environments e ON es.environment_id = e.id
+		INNER JOIN projects p ON e.project_id = p.id
+		WHERE p.name = ? AND e.environment_type = ?
+		ORDER BY s.id`
+
+	rows, err := tx.Query(query, projectName, environmentType)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching secrets: %v", err)
+	}
+
+	type currentSecret struct {
+		id            int
+		ref           SecretRef
+		deleted       bool
+		latestVersion int
+	}
+	var current []currentSecret
+	for rows.Next() {
+		var c currentSecret
+		var latest sql.NullInt64
+		if err := rows.Scan(&c.id, &c.ref.Key, &c.ref.Location, &c.deleted, &latest); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		c.latestVersion = int(latest.Int64)
+		current = append(current, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+
+	var changed []SecretRef
+	for _, c := range current {
+		var version int
+		err := tx.QueryRow(`
+			SELECT COALESCE(MAX(version), 0) FROM secret_versions
+			WHERE secret_id = ? AND created_at <= ?`,
+			c.id, formatTimestamp(at)).Scan(&version)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching history of %s: %v", c.ref.Key, err)
+		}
+
+		if version == c.latestVersion {
+			continue
+		}
+
+		if version == 0 {
+			// The secret did not exist yet at that time
+			if c.deleted {
+				continue
+			}
+			err = markSecretDeleted(tx, actor, c.id)
+		} else {
+			var target *storedVersion
+			target, err = getSecretVersion(tx, c.id, version)
+			if err == nil {
+				err = restoreVersion(tx, ring, actor, c.id, c.deleted, target)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error rolling back %s: %v", c.ref.Key, err)
+		}
+
+		if err := recordAudit(tx, actor, projectName, environmentType, c.ref.Key, AuditRollback); err != nil {
+			return nil, err
+		}
+		changed = append(changed, c.ref)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing rollback: %v", err)
+	}
+	return changed, nil
+}
@@ -0,0 +1,237 @@
+package db
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations are numbered SQL files embedded into the binary, one directory per
+// SQL dialect. Each file is applied once, inside a transaction, and recorded in
+// schema_migrations. A schema change adds a file with the same version to every
+// dialect's directory.
+
+//go:embed migrations/sqlite/*.sql migrations/postgres/*.sql
+var migrationFiles embed.FS
+
+// Migration is a single numbered schema change
+type Migration struct {
+	Version   int
+	Name      string
+	SQL       string
+	AppliedAt *time.Time // nil when the migration has not been applied yet
+}
+
+// loadMigrations reads the embedded migration files of a dialect sorted by version
+func loadMigrations(dialect string) ([]Migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := migrationFiles.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading embedded migrations: %v", err)
+	}
+
+	var migrations []Migration
+	for _, entry := range entries {
+		fileName := entry.Name()
+		prefix, name, found := strings.Cut(strings.TrimSuffix(fileName, ".sql"), "_")
+		if !found {
+			return nil, fmt.Errorf("invalid migration file name: %s", fileName)
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %v", fileName, err)
+		}
+
+		content, err := migrationFiles.ReadFile(path.Join(dir, fileName))
+		if err != nil {
+			return nil, fmt.Errorf("error reading migration %s: %v", fileName, err)
+		}
+
+		migrations = append(migrations, Migration{Version: version, Name: name, SQL: string(content)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version %d", migrations[i].Version)
+		}
+	}
+
+	return migrations, nil
+}
+
+// splitStatements splits a migration file into individual statements, since
+// not every driver accepts several statements in a single Exec. Semicolons
+// inside quotes, inside $$ quoted function bodies and inside CREATE TRIGGER ...
+// END bodies do not end a statement.
+func splitStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+	inQuote, inDollarQuote := false, false
+
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if !inQuote && !inDollarQuote && (trimmed == "" || strings.HasPrefix(trimmed, "--")) {
+			continue
+		}
+
+		for i, r := range line {
+			if r == '\'' && !inDollarQuote {
+				inQuote = !inQuote
+			}
+			if r == '$' && !inQuote && strings.HasPrefix(line[i:], "$$") && !strings.HasSuffix(current.String(), "$") {
+				inDollarQuote = !inDollarQuote
+			}
+			if r == ';' && !inQuote && !inDollarQuote && !inTriggerBody(current.String()) {
+				if stmt := strings.TrimSpace(current.String()); stmt != "" {
+					statements = append(statements, stmt)
+				}
+				current.Reset()
+				continue
+			}
+			current.WriteRune(r)
+		}
+		current.WriteString("\n")
+	}
+
+	if stmt := strings.TrimSpace(current.String()); stmt != "" {
+		statements = append(statements, stmt)
+	}
+	return statements
+}
+
+// inTriggerBody reports whether a partial statement is a SQLite trigger whose
+// BEGIN ... END body has not been closed yet
+func inTriggerBody(stmt string) bool {
+	upper := strings.ToUpper(strings.TrimSpace(stmt))
+	if !strings.HasPrefix(upper, "CREATE TRIGGER") || !strings.Contains(upper, "BEGIN") {
+		return false
+	}
+	return !strings.HasSuffix(upper, "END")
+}
+
+// ensureMigrationsTable creates the schema_migrations bookkeeping table
+func (db *sqlStore) ensureMigrationsTable() error {
+	query := `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`
+	if _, err := db.Exec(query); err != nil {
+		return fmt.Errorf("error creating schema_migrations table: %v", err)
+	}
+	return nil
+}
+
+// MigrationStatus returns every known migration along with when it was applied.
+// Versions recorded in the database that this binary does not know about are
+// reported as an error, as they mean the binary is older than the schema.
+func (db *sqlStore) MigrationStatus() ([]Migration, error) {
+	if err := db.ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+
+	migrations, err := loadMigrations(db.dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("error fetching applied migrations: %v", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAtStr string
+		if err := rows.Scan(&version, &appliedAtStr); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		appliedAt, err := parseTimestamp(appliedAtStr)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing applied_at of migration %d: %v", version, err)
+		}
+		applied[version] = appliedAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+
+	for i := range migrations {
+		if appliedAt, ok := applied[migrations[i].Version]; ok {
+			migrations[i].AppliedAt = &appliedAt
+			delete(applied, migrations[i].Version)
+		}
+	}
+	for version := range applied {
+		return nil, fmt.Errorf("database has migration %d which this version of sbx does not know about; please upgrade sbx", version)
+	}
+
+	return migrations, nil
+}
+
+// PendingMigrations returns the migrations that have not been applied yet
+func (db *sqlStore) PendingMigrations() ([]Migration, error) {
+	migrations, err := db.MigrationStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, migration := range migrations {
+		if migration.AppliedAt == nil {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies every pending migration in order and returns the ones it applied
+func (db *sqlStore) Migrate() ([]Migration, error) {
+	pending, err := db.PendingMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for _, migration := range pending {
+		if err := db.applyMigration(migration); err != nil {
+			return applied, err
+		}
+		applied = append(applied, migration)
+	}
+
+	return applied, nil
+}
+
+// applyMigration runs a single migration and records it, all in one transaction
+func (db *sqlStore) applyMigration(migration Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction for migration %d: %v", migration.Version, err)
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range splitStatements(migration.SQL) {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("error applying migration %d (%s): %v", migration.Version, migration.Name, err)
+		}
+	}
+
+	_, err = tx.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`, migration.Version, migration.Name, formatTimestamp(time.Now()))
+	if err != nil {
+		return fmt.Errorf("error recording migration %d: %v", migration.Version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing migration %d: %v", migration.Version, err)
+	}
+	return nil
+}
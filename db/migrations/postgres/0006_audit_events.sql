@@ -0,0 +1,28 @@
+-- Append-only audit log of secret reads and writes. Names are stored rather
+-- than foreign keys so events outlive the users and projects they mention.
+
+CREATE TABLE audit_events (
+    id SERIAL PRIMARY KEY,
+    actor_id INTEGER,
+    actor TEXT NOT NULL,
+    project TEXT NOT NULL,
+    environment TEXT NOT NULL DEFAULT '',
+    key TEXT NOT NULL DEFAULT '',
+    action TEXT NOT NULL,
+    hostname TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC')
+);
+
+CREATE INDEX audit_events_project_created_at ON audit_events (project, created_at);
+
+CREATE FUNCTION reject_modification() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION '% is append-only', TG_TABLE_NAME;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_no_update BEFORE UPDATE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION reject_modification();
+
+CREATE TRIGGER audit_events_no_delete BEFORE DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION reject_modification();
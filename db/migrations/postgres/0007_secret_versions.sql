@@ -0,0 +1,25 @@
+-- Immutable version history. Every write to a secret appends a row here, and
+-- deletions only mark the secret as deleted and append a deletion version.
+
+CREATE TABLE secret_versions (
+    id SERIAL PRIMARY KEY,
+    secret_id INTEGER NOT NULL REFERENCES secrets(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    value TEXT NOT NULL,
+    key_version INTEGER NOT NULL DEFAULT 0,
+    location TEXT NOT NULL DEFAULT '.',
+    deleted BOOLEAN NOT NULL DEFAULT FALSE,
+    created_by INTEGER REFERENCES users(id),
+    created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    UNIQUE (secret_id, version)
+);
+
+CREATE TRIGGER secret_versions_no_update BEFORE UPDATE ON secret_versions
+    FOR EACH ROW EXECUTE FUNCTION reject_modification();
+
+ALTER TABLE secrets ADD COLUMN deleted_at TIMESTAMP;
+
+-- Existing values become version 1 of their secret
+INSERT INTO secret_versions (secret_id, version, value, key_version, location, created_by, created_at)
+SELECT id, 1, value, key_version, location, updated_by, COALESCE(updated_at, NOW() AT TIME ZONE 'UTC')
+FROM secrets;
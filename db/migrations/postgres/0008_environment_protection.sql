@@ -0,0 +1,6 @@
+-- Environments are no longer a fixed set, so whether changes to an environment
+-- need confirmation is stored with it instead of being hardcoded.
+
+ALTER TABLE environments ADD COLUMN protected BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE environments SET protected = TRUE WHERE environment_type IN ('staging', 'production');
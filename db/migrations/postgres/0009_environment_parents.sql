@@ -0,0 +1,4 @@
+-- An environment can inherit the secrets of a parent environment and override
+-- individual keys, so values shared by several environments are stored once.
+
+ALTER TABLE environments ADD COLUMN parent_id INTEGER REFERENCES environments(id);
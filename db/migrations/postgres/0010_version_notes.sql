@@ -0,0 +1,4 @@
+-- A version can carry a note explaining where it came from, such as the
+-- environment a value was promoted from.
+
+ALTER TABLE secret_versions ADD COLUMN note TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,13 @@
+-- Envelope encryption: wrapped per-project data keys and the key version each
+-- secret value was encrypted with (0 = legacy plaintext).
+
+CREATE TABLE project_keys (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    wrapped_key TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (project_id, version)
+);
+
+ALTER TABLE secrets ADD COLUMN key_version INTEGER NOT NULL DEFAULT 0;
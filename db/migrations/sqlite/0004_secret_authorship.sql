@@ -0,0 +1,5 @@
+-- Track who last changed each secret and when.
+
+ALTER TABLE secrets ADD COLUMN updated_by INTEGER REFERENCES users(id);
+
+ALTER TABLE secrets ADD COLUMN updated_at TIMESTAMP;
@@ -0,0 +1,17 @@
+-- Role based access control. A member without rows in member_environments
+-- may access every environment of the project.
+
+CREATE TABLE project_members (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (project_id, user_id)
+);
+
+CREATE TABLE member_environments (
+    member_id INTEGER NOT NULL REFERENCES project_members(id) ON DELETE CASCADE,
+    environment_id INTEGER NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+    PRIMARY KEY (member_id, environment_id)
+);
@@ -0,0 +1,26 @@
+-- Append-only audit log of secret reads and writes. Names are stored rather
+-- than foreign keys so events outlive the users and projects they mention.
+
+CREATE TABLE audit_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    actor_id INTEGER,
+    actor TEXT NOT NULL,
+    project TEXT NOT NULL,
+    environment TEXT NOT NULL DEFAULT '',
+    key TEXT NOT NULL DEFAULT '',
+    action TEXT NOT NULL,
+    hostname TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX audit_events_project_created_at ON audit_events (project, created_at);
+
+CREATE TRIGGER audit_events_no_update BEFORE UPDATE ON audit_events
+BEGIN
+    SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+
+CREATE TRIGGER audit_events_no_delete BEFORE DELETE ON audit_events
+BEGIN
+    SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
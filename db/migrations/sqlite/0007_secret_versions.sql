@@ -0,0 +1,27 @@
+-- Immutable version history. Every write to a secret appends a row here, and
+-- deletions only mark the secret as deleted and append a deletion version.
+
+CREATE TABLE secret_versions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    secret_id INTEGER NOT NULL REFERENCES secrets(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    value TEXT NOT NULL,
+    key_version INTEGER NOT NULL DEFAULT 0,
+    location TEXT NOT NULL DEFAULT '.',
+    deleted BOOLEAN NOT NULL DEFAULT 0,
+    created_by INTEGER REFERENCES users(id),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (secret_id, version)
+);
+
+CREATE TRIGGER secret_versions_no_update BEFORE UPDATE ON secret_versions
+BEGIN
+    SELECT RAISE(ABORT, 'secret_versions is immutable');
+END;
+
+ALTER TABLE secrets ADD COLUMN deleted_at TIMESTAMP;
+
+-- Existing values become version 1 of their secret
+INSERT INTO secret_versions (secret_id, version, value, key_version, location, created_by, created_at)
+SELECT id, 1, value, key_version, location, updated_by, COALESCE(updated_at, CURRENT_TIMESTAMP)
+FROM secrets;
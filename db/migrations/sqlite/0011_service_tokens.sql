@@ -0,0 +1,19 @@
+-- Service tokens let CI and other machines read or write the secrets of one
+-- project, optionally of a single environment, without a user's password.
+-- Only a SHA-256 hash of each token is stored.
+
+CREATE TABLE service_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL DEFAULT '',
+    token_hash TEXT NOT NULL UNIQUE,
+    project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    environment_id INTEGER REFERENCES environments(id) ON DELETE CASCADE,
+    read_only BOOLEAN NOT NULL DEFAULT 1,
+    created_by INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP,
+    last_used_at TIMESTAMP
+);
+
+CREATE INDEX service_tokens_project_id ON service_tokens (project_id);
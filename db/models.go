@@ -1,39 +1,64 @@
 package db
 
-type EnvironmentType string
-
-const (
-	Development EnvironmentType = "development"
-	Staging     EnvironmentType = "staging"
-	Production  EnvironmentType = "production"
-)
+import "time"
 
 type User struct {
-	ID       int
-	Email    string
-	Password string
-	Admin    bool
+	ID       int    `json:"id"`
+	Email    string `json:"email"`
+	Password string `json:"-"` // argon2id hash, never sent over the API
+	Admin    bool   `json:"admin"`
+
+	// Token is the service token the user is acting through, nil for a login
+	// session. The user is then the token's creator, limited to its scope.
+	Token *ServiceToken `json:"token,omitempty"`
+
+	// Hostname is the machine the user is acting from, recorded in audit
+	// events. It is set by the sbx server; empty means this machine.
+	Hostname string `json:"-"`
 }
 
 type Secret struct {
-	ID       int
-	Key      string
-	Creator  User
-	Value    string
-	Location string
+	ID         int       `json:"id"`
+	Key        string    `json:"key"`
+	Creator    User      `json:"creator"`
+	Value      string    `json:"value"`       // Decrypted value; the database only stores ciphertext
+	KeyVersion int       `json:"key_version"` // Version of the project data key the value was encrypted with
+	Location   string    `json:"location"`
+	UpdatedBy  User      `json:"updated_by"` // The user who last created or changed the value
+	UpdatedAt  time.Time `json:"updated_at"` // Zero for secrets written before changes were tracked
+	Source     string    `json:"source"`     // Environment the value is defined in; an ancestor of the requested environment when inherited
+}
+
+// SecretVersion is one immutable entry in the history of a secret
+type SecretVersion struct {
+	Version    int       `json:"version"`
+	Value      string    `json:"value"` // Decrypted value, empty for deletions
+	KeyVersion int       `json:"key_version"`
+	Location   string    `json:"location"`
+	Deleted    bool      `json:"deleted"` // The secret was deleted in this version
+	Note       string    `json:"note"`    // Why the version was written, e.g. "promoted from staging"
+	CreatedBy  User      `json:"created_by"`
+	CreatedAt  time.Time `json:"created_at"`
 }
 
 type Environment struct {
-	ID      int
-	Secrets map[string]Secret // A map of Secrets (key-value pairs)
-	Type    EnvironmentType
+	ID        int               `json:"id"`
+	Name      string            `json:"name"`
+	Protected bool              `json:"protected"`         // Changes must be confirmed before they are written
+	Parent    string            `json:"parent"`            // Environment whose secrets this one inherits, empty for none
+	Secrets   map[string]Secret `json:"secrets,omitempty"` // A map of Secrets (key-value pairs)
+}
+
+// DefaultEnvironments are created along with every new project
+var DefaultEnvironments = []Environment{
+	{Name: "development"},
+	{Name: "staging", Protected: true},
+	{Name: "production", Protected: true},
 }
 
 type Project struct {
-	ID          int
-	Name        string
-	Development Environment
-	Staging     Environment
-	Production  Environment
-	Active      bool // Indicates if the project has been sunset or not
+	ID           int           `json:"id"`
+	Name         string        `json:"name"`
+	Environments []Environment `json:"environments"`
+	Active       bool          `json:"active"` // Indicates if the project has been sunset or not
 }
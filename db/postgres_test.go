@@ -0,0 +1,47 @@
+package db
+
+import "testing"
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT 1", "SELECT 1"},
+		{"SELECT id FROM projects WHERE name = ?", "SELECT id FROM projects WHERE name = $1"},
+		{"INSERT INTO t (a, b) VALUES (?, ?), (?, ?)", "INSERT INTO t (a, b) VALUES ($1, $2), ($3, $4)"},
+		{"SELECT * FROM t WHERE a = '?' AND b = ?", "SELECT * FROM t WHERE a = '?' AND b = $1"},
+		{"SELECT * FROM t WHERE a = 'it''s ?' AND b = ?", "SELECT * FROM t WHERE a = 'it''s ?' AND b = $1"},
+	}
+
+	for _, test := range tests {
+		if got := rebind(test.query); got != test.want {
+			t.Errorf("rebind(%q) = %q, want %q", test.query, got, test.want)
+		}
+	}
+}
+
+// Every schema change is made to each dialect, so their migrations must line up
+func TestMigrationDialects(t *testing.T) {
+	sqlite, err := loadMigrations(dialectSQLite)
+	if err != nil {
+		t.Fatalf("loadMigrations(sqlite): %v", err)
+	}
+	postgres, err := loadMigrations(dialectPostgres)
+	if err != nil {
+		t.Fatalf("loadMigrations(postgres): %v", err)
+	}
+
+	if len(sqlite) != len(postgres) {
+		t.Fatalf("got %d sqlite and %d postgres migrations", len(sqlite), len(postgres))
+	}
+	for i := range sqlite {
+		if sqlite[i].Version != postgres[i].Version || sqlite[i].Name != postgres[i].Name {
+			t.Errorf("migration %d is %d_%s for sqlite but %d_%s for postgres",
+				i, sqlite[i].Version, sqlite[i].Name, postgres[i].Version, postgres[i].Name)
+		}
+		if len(splitStatements(postgres[i].SQL)) == 0 {
+			t.Errorf("postgres migration %d_%s has no statements", postgres[i].Version, postgres[i].Name)
+		}
+	}
+}
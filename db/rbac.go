@@ -0,0 +1,305 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Access to a project is granted through project memberships. Each member has
+// a role, and may additionally be restricted to a subset of the project's
+// environments; a member without environment restrictions can access every
+// environment. Global admins (users.admin) bypass these checks entirely.
+
+// Role is the level of access a member has to a project
+type Role string
+
+const (
+	RoleViewer     Role = "viewer"     // read secrets
+	RoleDeveloper  Role = "developer"  // read and write secrets
+	RoleMaintainer Role = "maintainer" // developer, plus project maintenance such as key rotation
+	RoleAdmin      Role = "admin"      // maintainer, plus managing members
+)
+
+// Roles lists the valid roles from least to most privileged
+var Roles = []Role{RoleViewer, RoleDeveloper, RoleMaintainer, RoleAdmin}
+
+// Permission is an action checked against a member's role
+type Permission int
+
+const (
+	PermRead Permission = iota + 1
+	PermWrite
+	PermMaintain
+	PermAdmin
+)
+
+func (p Permission) String() string {
+	switch p {
+	case PermRead:
+		return "read"
+	case PermWrite:
+		return "write"
+	case PermMaintain:
+		return "maintain"
+	case PermAdmin:
+		return "administer"
+	default:
+		return "unknown"
+	}
+}
+
+// ErrPermissionDenied is wrapped by every authorization failure
+var ErrPermissionDenied = errors.New("permission denied")
+
+// ParseRole validates a role name
+func ParseRole(name string) (Role, error) {
+	for _, role := range Roles {
+		if string(role) == strings.ToLower(name) {
+			return role, nil
+		}
+	}
+	return "", invalidf("invalid role '%s' (valid roles: viewer, developer, maintainer, admin)", name)
+}
+
+// Allows reports whether the role grants the permission
+func (r Role) Allows(perm Permission) bool {
+	for i, role := range Roles {
+		if role == r {
+			return i+1 >= int(perm)
+		}
+	}
+	return false
+}
+
+// Member is a user's membership in a project
+type Member struct {
+	User         User     `json:"user"`
+	Role         Role     `json:"role"`
+	Environments []string `json:"environments"` // Empty when the member may access every environment
+}
+
+// authorize checks that the actor may perform perm on the project, and on the
+// given environment when environmentType is not empty. An actor using a
+// service token needs both the token and its creator to allow it.
+func authorize(db querier, actor User, projectName, environmentType string, perm Permission) error {
+	if actor.Token != nil {
+		if err := authorizeToken(actor.Token, projectName, environmentType, perm); err != nil {
+			return err
+		}
+		actor = actor.Token.CreatedBy
+	}
+
+	if actor.Admin {
+		return nil
+	}
+
+	var memberID int
+	var role Role
+	err := db.QueryRow(`
+		SELECT m.id, m.role
+		FROM project_members m
+		INNER JOIN projects p ON m.project_id = p.id
+		WHERE p.name = ? AND m.user_id = ?`,
+		projectName, actor.ID).Scan(&memberID, &role)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: %s is not a member of project '%s'", ErrPermissionDenied, actor.Email, projectName)
+	}
+	if err != nil {
+		return fmt.Errorf("error checking project membership: %v", err)
+	}
+
+	if !role.Allows(perm) {
+		return fmt.Errorf("%w: role '%s' cannot %s project '%s'", ErrPermissionDenied, role, perm, projectName)
+	}
+
+	if environmentType == "" {
+		return nil
+	}
+
+	environments, err := memberEnvironments(db, memberID)
+	if err != nil {
+		return err
+	}
+	if len(environments) == 0 {
+		return nil
+	}
+	for _, env := range environments {
+		if env == environmentType {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s has no access to the %s environment of project '%s'", ErrPermissionDenied, actor.Email, environmentType, projectName)
+}
+
+// memberEnvironments returns the environments a member is restricted to
+func memberEnvironments(db querier, memberID int) ([]string, error) {
+	query := `
+		SELECT e.environment_type
+		FROM member_environments me
+		INNER JOIN environments e ON me.environment_id = e.id
+		WHERE me.member_id = ?`
+
+	rows, err := db.Query(query, memberID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching member environments: %v", err)
+	}
+	defer rows.Close()
+
+	var environments []string
+	for rows.Next() {
+		var env string
+		if err := rows.Scan(&env); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		environments = append(environments, env)
+	}
+	return environments, rows.Err()
+}
+
+// AddMember adds a user to a project, or updates the role and environment
+// restrictions of an existing member. An empty environments list grants
+// access to every environment. The member and its restrictions are replaced
+// in one transaction, as a member left without restrictions could access
+// every environment.
+func (db *sqlStore) AddMember(actor User, projectName, email string, role Role, environments []string) error {
+	role, err := ParseRole(string(role))
+	if err != nil {
+		return err
+	}
+	if err := authorize(db, actor, projectName, "", PermAdmin); err != nil {
+		return err
+	}
+
+	var projectID, userID int
+	err = db.QueryRow("SELECT id FROM projects WHERE name = ?", projectName).Scan(&projectID)
+	if err != nil {
+		return fmt.Errorf("error finding project ID: %v", err)
+	}
+	err = db.QueryRow("SELECT id FROM users WHERE email = ?", email).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return invalidf("no user with email '%s'", email)
+	}
+	if err != nil {
+		return fmt.Errorf("error finding user ID: %v", err)
+	}
+
+	// Resolve the environment IDs up front so a typo doesn't leave a half-updated member
+	var environmentIDs []int
+	for _, env := range environments {
+		var environmentID int
+		err := db.QueryRow("SELECT id FROM environments WHERE project_id = ? AND environment_type = ?", projectID, env).Scan(&environmentID)
+		if err == sql.ErrNoRows {
+			return invalidf("project '%s' has no %s environment", projectName, env)
+		}
+		if err != nil {
+			return fmt.Errorf("error finding environment ID: %v", err)
+		}
+		environmentIDs = append(environmentIDs, environmentID)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var memberID int
+	err = tx.QueryRow("SELECT id FROM project_members WHERE project_id = ? AND user_id = ?", projectID, userID).Scan(&memberID)
+	switch {
+	case err == sql.ErrNoRows:
+		query := `INSERT INTO project_members (project_id, user_id, role) VALUES (?, ?, ?) RETURNING id`
+		if err := tx.QueryRow(query, projectID, userID, role).Scan(&memberID); err != nil {
+			return fmt.Errorf("error adding member: %v", err)
+		}
+	case err != nil:
+		return fmt.Errorf("error checking project membership: %v", err)
+	default:
+		if _, err := tx.Exec(`UPDATE project_members SET role = ? WHERE id = ?`, role, memberID); err != nil {
+			return fmt.Errorf("error updating member: %v", err)
+		}
+		if _, err := tx.Exec(`DELETE FROM member_environments WHERE member_id = ?`, memberID); err != nil {
+			return fmt.Errorf("error clearing member environments: %v", err)
+		}
+	}
+
+	for _, environmentID := range environmentIDs {
+		_, err := tx.Exec(`INSERT INTO member_environments (member_id, environment_id) VALUES (?, ?)`, memberID, environmentID)
+		if err != nil {
+			return fmt.Errorf("error restricting member environments: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing member: %v", err)
+	}
+	return nil
+}
+
+// RemoveMember removes a user from a project
+func (db *sqlStore) RemoveMember(actor User, projectName, email string) error {
+	if err := authorize(db, actor, projectName, "", PermAdmin); err != nil {
+		return err
+	}
+
+	query := `
+		DELETE FROM project_members
+		WHERE project_id = (SELECT id FROM projects WHERE name = ?)
+		AND user_id = (SELECT id FROM users WHERE email = ?)`
+
+	res, err := db.Exec(query, projectName, email)
+	if err != nil {
+		return fmt.Errorf("error removing member: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return invalidf("%s is not a member of project '%s'", email, projectName)
+	}
+	return nil
+}
+
+// ListMembers returns the members of a project
+func (db *sqlStore) ListMembers(actor User, projectName string) ([]Member, error) {
+	if err := authorize(db, actor, projectName, "", PermRead); err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT m.id, u.id, u.email, u.admin, m.role
+		FROM project_members m
+		INNER JOIN projects p ON m.project_id = p.id
+		INNER JOIN users u ON m.user_id = u.id
+		WHERE p.name = ?
+		ORDER BY u.email`
+
+	rows, err := db.Query(query, projectName)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching members: %v", err)
+	}
+
+	var memberIDs []int
+	var members []Member
+	for rows.Next() {
+		var memberID int
+		var member Member
+		if err := rows.Scan(&memberID, &member.User.ID, &member.User.Email, &member.User.Admin, &member.Role); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		memberIDs = append(memberIDs, memberID)
+		members = append(members, member)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+
+	for i, memberID := range memberIDs {
+		members[i].Environments, err = memberEnvironments(db, memberID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return members, nil
+}
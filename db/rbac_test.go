@@ -0,0 +1,104 @@
+package db
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestRoleAllows(t *testing.T) {
+	// The permissions each role is granted, every other one is refused
+	granted := map[Role][]Permission{
+		RoleViewer:     {PermRead},
+		RoleDeveloper:  {PermRead, PermWrite},
+		RoleMaintainer: {PermRead, PermWrite, PermMaintain},
+		RoleAdmin:      {PermRead, PermWrite, PermMaintain, PermAdmin},
+		"owner":        nil,
+	}
+
+	for role, perms := range granted {
+		for _, perm := range []Permission{PermRead, PermWrite, PermMaintain, PermAdmin} {
+			if got, want := role.Allows(perm), slices.Contains(perms, perm); got != want {
+				t.Errorf("%s.Allows(%s) = %v, want %v", role, perm, got, want)
+			}
+		}
+	}
+}
+
+func TestParseRole(t *testing.T) {
+	for _, role := range Roles {
+		if got, err := ParseRole(string(role)); err != nil || got != role {
+			t.Errorf("ParseRole(%q) = %q, %v", role, got, err)
+		}
+	}
+	if got, err := ParseRole("Developer"); err != nil || got != RoleDeveloper {
+		t.Errorf("ParseRole(Developer) = %q, %v", got, err)
+	}
+	for _, name := range []string{"", "owner", "admins"} {
+		if _, err := ParseRole(name); err == nil {
+			t.Errorf("ParseRole(%q) succeeded", name)
+		}
+	}
+}
+
+func TestMembers(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		owner := newTestUser(t, store, "owner@example.com", false)
+		member := newTestUser(t, store, "member@example.com", false)
+		if err := store.CreateProject(owner, "api"); err != nil {
+			t.Fatalf("CreateProject: %v", err)
+		}
+
+		steps := []struct {
+			name         string
+			role         Role
+			environments []string
+			fails        bool
+		}{
+			{name: "add restricted", role: RoleDeveloper, environments: []string{"development", "staging"}},
+			{name: "change role and restriction", role: RoleViewer, environments: []string{"production"}},
+			{name: "unknown environment", role: RoleAdmin, environments: []string{"qa"}, fails: true},
+			{name: "lift restriction", role: RoleMaintainer},
+		}
+
+		want := Member{}
+		for _, step := range steps {
+			err := store.AddMember(owner, "api", member.Email, step.role, step.environments)
+			if step.fails {
+				if err == nil {
+					t.Fatalf("%s: AddMember succeeded", step.name)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("%s: AddMember: %v", step.name, err)
+				}
+				want = Member{Role: step.role, Environments: step.environments}
+			}
+
+			// A failed update leaves the member as it was
+			members, err := store.ListMembers(owner, "api")
+			if err != nil {
+				t.Fatalf("%s: ListMembers: %v", step.name, err)
+			}
+			index := slices.IndexFunc(members, func(m Member) bool { return m.User.ID == member.ID })
+			if index < 0 {
+				t.Fatalf("%s: %s is not a member", step.name, member.Email)
+			}
+			got := members[index]
+			slices.Sort(got.Environments)
+			if got.Role != want.Role || !slices.Equal(got.Environments, want.Environments) {
+				t.Errorf("%s: member is %s of %v, want %s of %v", step.name, got.Role, got.Environments, want.Role, want.Environments)
+			}
+		}
+
+		if err := store.RemoveMember(owner, "api", member.Email); err != nil {
+			t.Fatalf("RemoveMember: %v", err)
+		}
+		if _, err := store.GetSecrets(member, "api", "development"); !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("GetSecrets after RemoveMember = %v, want permission denied", err)
+		}
+		if err := store.RemoveMember(owner, "api", member.Email); err == nil {
+			t.Error("RemoveMember succeeded for a user who is not a member")
+		}
+	})
+}
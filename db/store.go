@@ -0,0 +1,171 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/tursodatabase/libsql-client-go/libsql"
+	_ "modernc.org/sqlite"
+)
+
+// Store is where sbx keeps its users, projects and secrets. Operations taking
+// an actor check the actor's permissions themselves.
+type Store interface {
+	// Users and sessions
+	CreateUser(email, password string, admin bool) error
+	CountUsers() (int, error)
+	ListUsers(actor User) ([]User, error)
+	Login(email, password string) (User, string, time.Time, error)
+	GetSessionUser(token string) (User, error)
+	DeleteSession(token string) error
+
+	// Projects and members
+	CreateProject(creator User, name string) error
+	ProjectExists(name string) (bool, error)
+	ListProjects(actor User) ([]Project, error)
+	RotateProjectKey(actor User, projectName string) (int, error)
+	AddMember(actor User, projectName, email string, role Role, environments []string) error
+	RemoveMember(actor User, projectName, email string) error
+	ListMembers(actor User, projectName string) ([]Member, error)
+
+	// Environments
+	GetEnvironment(projectName, name string) (Environment, error)
+	ListEnvironments(actor User, projectName string) ([]Environment, error)
+	CreateEnvironment(actor User, projectName string, env Environment) error
+	SetEnvironmentParent(actor User, projectName, name, parentName string) error
+	DeleteEnvironment(actor User, projectName, name string, force bool) error
+
+	// Secrets
+	SecretExists(actor User, key, location, projectName, environmentType string) (bool, error)
+	CreateSecret(creator User, key, value, location, projectName, environmentType string) error
+	UpdateSecret(editor User, key, value, location, projectName, environmentType string) error
+	DeleteSecret(actor User, key, location, projectName, environmentType string) error
+	GetAllSecretsKeys(actor User, projectName, environmentType string) ([]string, error)
+	CompleteKeys(actor User, projectName, environmentType string) ([]string, error)
+	GetSecrets(actor User, projectName, environmentType string) ([]Secret, error)
+	ApplyChangeset(actor User, projectName, environmentType string, changeset Changeset) error
+
+	// Service tokens
+	CreateServiceToken(actor User, projectName, environmentType, name string, readOnly bool, ttl time.Duration) (string, ServiceToken, error)
+	ListServiceTokens(actor User, projectName string) ([]ServiceToken, error)
+	RevokeServiceToken(actor User, projectName string, id int) error
+
+	// History and audit
+	SecretHistory(actor User, key, location, projectName, environmentType string) ([]SecretVersion, error)
+	RollbackSecret(actor User, key, location, projectName, environmentType string, version int) error
+	RollbackEnvironment(actor User, projectName, environmentType string, at time.Time) ([]SecretRef, error)
+	ListAuditEvents(actor User, filter AuditFilter) ([]AuditEvent, error)
+
+	// Schema
+	MigrationStatus() ([]Migration, error)
+	PendingMigrations() ([]Migration, error)
+	Migrate() ([]Migration, error)
+
+	Close() error
+}
+
+// ErrInvalidRequest is wrapped by errors caused by the request rather than by
+// the store: invalid input, or data that rules the request out, such as a
+// secret that already exists. Their messages are meant for the user.
+var ErrInvalidRequest = errors.New("invalid request")
+
+// invalidRequest keeps the message of an error while wrapping ErrInvalidRequest
+type invalidRequest struct{ error }
+
+func (e invalidRequest) Is(target error) bool { return target == ErrInvalidRequest }
+func (e invalidRequest) Unwrap() error        { return e.error }
+
+// invalidf formats an error caused by the request
+func invalidf(format string, args ...interface{}) error {
+	return invalidRequest{fmt.Errorf(format, args...)}
+}
+
+// SQL dialects, each with its own directory of migrations
+const (
+	dialectSQLite   = "sqlite"
+	dialectPostgres = "postgres"
+)
+
+// sqlStore implements Store on a SQL database. Turso/libsql, SQLite and
+// Postgres all use it: queries stick to SQL the three understand, with ?
+// placeholders, and the dialect only selects the schema migrations.
+type sqlStore struct {
+	*sql.DB
+	dialect string
+}
+
+// Open connects to the database at url, choosing the backend by the scheme:
+//
+//	libsql://, https://, wss://   Turso or another libsql server
+//	sqlite:PATH, file:PATH        a local SQLite file
+//	sqlite::memory:               a private in-memory SQLite database
+//	postgres://, postgresql://    Postgres
+//
+// The schema is not checked, see ConnectToDB.
+func Open(url string) (Store, error) {
+	scheme, rest, found := strings.Cut(url, ":")
+	if !found {
+		return nil, fmt.Errorf("invalid database URL %q, it must start with a scheme such as sqlite: or postgres:", url)
+	}
+
+	var driver, dsn, dialect string
+	switch strings.ToLower(scheme) {
+	case "libsql", "http", "https", "ws", "wss":
+		driver, dsn, dialect = "libsql", url, dialectSQLite
+	case "sqlite", "file":
+		driver, dsn, dialect = "sqlite", sqliteDSN(rest), dialectSQLite
+	case "postgres", "postgresql":
+		driver, dsn, dialect = postgresDriver, url, dialectPostgres
+	default:
+		return nil, fmt.Errorf("unsupported database URL scheme %q (supported: libsql, sqlite, file, postgres)", scheme)
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("error opening database: %v", err)
+	}
+	if strings.Contains(dsn, ":memory:") {
+		// Every connection to :memory: would get a database of its own
+		db.SetMaxOpenConns(1)
+	}
+
+	// Ping the database to ensure the connection is successful
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error connecting to the database: %v", err)
+	}
+
+	return &sqlStore{DB: db, dialect: dialect}, nil
+}
+
+// OpenMemory returns a migrated store kept in memory, for tests and
+// experiments. Everything is lost when it is closed.
+func OpenMemory() (Store, error) {
+	store, err := Open("sqlite::memory:")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := store.Migrate(); err != nil {
+		store.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// sqliteDSN turns the part of a sqlite: or file: URL after the scheme into a
+// data source name for the SQLite driver, enforcing foreign keys as Postgres does
+func sqliteDSN(path string) string {
+	path = strings.TrimPrefix(path, "//")
+	if path == ":memory:" || path == "" {
+		path = ":memory:"
+	}
+
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	return "file:" + path + separator + "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)"
+}
@@ -0,0 +1,499 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testBackends open an empty, migrated store for each test. Postgres needs a
+// server to run on, given as SBX_TEST_POSTGRES_URL, and is skipped without one.
+var testBackends = []struct {
+	name string
+	open func(t *testing.T) Store
+}{
+	{"sqlite", openSQLiteTestStore},
+	{"postgres", openPostgresTestStore},
+}
+
+// forEachBackend runs a test against a fresh store of every backend, with a
+// master key set
+func forEachBackend(t *testing.T, test func(t *testing.T, store Store)) {
+	t.Helper()
+	t.Setenv(masterKeyEnv, base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32))))
+
+	for _, backend := range testBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			test(t, backend.open(t))
+		})
+	}
+}
+
+func openSQLiteTestStore(t *testing.T) Store {
+	t.Helper()
+	store, err := OpenMemory()
+	if err != nil {
+		t.Fatalf("OpenMemory: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// openPostgresTestStore migrates a schema of its own, dropped after the test,
+// so tests never see each other's data
+func openPostgresTestStore(t *testing.T) Store {
+	t.Helper()
+	url := os.Getenv("SBX_TEST_POSTGRES_URL")
+	if url == "" {
+		t.Skip("SBX_TEST_POSTGRES_URL is not set")
+	}
+
+	admin, err := sql.Open(postgresDriver, url)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := fmt.Sprintf("sbx_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatalf("creating schema: %v", err)
+	}
+	t.Cleanup(func() { admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+	separator := "?"
+	if strings.Contains(url, "?") {
+		separator = "&"
+	}
+	store, err := Open(url + separator + "search_path=" + schema)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	if _, err := store.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	return store
+}
+
+// newTestUser registers a user and returns it as it is after logging in
+func newTestUser(t *testing.T, store Store, email string, admin bool) User {
+	t.Helper()
+	if err := store.CreateUser(email, "password", admin); err != nil {
+		t.Fatalf("CreateUser(%s): %v", email, err)
+	}
+	user, _, _, err := store.Login(email, "password")
+	if err != nil {
+		t.Fatalf("Login(%s): %v", email, err)
+	}
+	return user
+}
+
+// secretValues returns the secrets of an environment by key
+func secretValues(t *testing.T, store Store, actor User, projectName, environmentType string) map[string]string {
+	t.Helper()
+	secrets, err := store.GetSecrets(actor, projectName, environmentType)
+	if err != nil {
+		t.Fatalf("GetSecrets(%s): %v", environmentType, err)
+	}
+	values := make(map[string]string)
+	for _, secret := range secrets {
+		values[secret.Key] = secret.Value
+	}
+	return values
+}
+
+func TestCreateProject(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		owner := newTestUser(t, store, "owner@example.com", false)
+
+		if err := store.CreateProject(owner, "api"); err != nil {
+			t.Fatalf("CreateProject: %v", err)
+		}
+		if err := store.CreateProject(owner, "api"); err == nil {
+			t.Error("CreateProject succeeded for an existing project")
+		}
+
+		environments, err := store.ListEnvironments(owner, "api")
+		if err != nil {
+			t.Fatalf("ListEnvironments: %v", err)
+		}
+		if len(environments) != len(DefaultEnvironments) {
+			t.Errorf("got %d environments, want %d", len(environments), len(DefaultEnvironments))
+		}
+
+		members, err := store.ListMembers(owner, "api")
+		if err != nil {
+			t.Fatalf("ListMembers: %v", err)
+		}
+		if len(members) != 1 || members[0].User.ID != owner.ID || members[0].Role != RoleAdmin {
+			t.Errorf("members = %+v, want the creator as admin", members)
+		}
+
+		// Without a master key nothing is written
+		t.Setenv(masterKeyEnv, "")
+		if err := store.CreateProject(owner, "web"); err == nil {
+			t.Error("CreateProject succeeded without a master key")
+		}
+		if exists, err := store.ProjectExists("web"); err != nil || exists {
+			t.Errorf("ProjectExists(web) = %v, %v after a failed CreateProject", exists, err)
+		}
+	})
+}
+
+func TestApplyChangeset(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		owner := newTestUser(t, store, "owner@example.com", false)
+		if err := store.CreateProject(owner, "api"); err != nil {
+			t.Fatalf("CreateProject: %v", err)
+		}
+
+		// More secrets than fit in one batch
+		var set []SecretWrite
+		for i := 0; i < 2*batchSize+10; i++ {
+			set = append(set, SecretWrite{Key: fmt.Sprintf("KEY_%03d", i), Value: fmt.Sprint(i), Location: ".env"})
+		}
+		set = append(set, SecretWrite{Key: "PORT", Value: "8080", Location: "api/.env"}, SecretWrite{Key: "PORT", Value: "3000", Location: "web/.env"})
+
+		steps := []struct {
+			name      string
+			changeset Changeset
+			want      map[string]string // Values expected afterwards, "" for deleted keys
+			count     int               // Secrets expected afterwards, counting PORT once per location
+		}{
+			{
+				name:      "create",
+				changeset: Changeset{Set: set},
+				want:      map[string]string{"KEY_000": "0", "KEY_209": "209"},
+				count:     len(set),
+			},
+			{
+				name:      "update and delete",
+				changeset: Changeset{Set: []SecretWrite{{Key: "KEY_000", Value: "changed", Location: ".env"}}, Delete: []SecretRef{{Key: "KEY_001", Location: ".env"}}},
+				want:      map[string]string{"KEY_000": "changed", "KEY_001": ""},
+				count:     len(set) - 1,
+			},
+			{
+				name:      "last write wins",
+				changeset: Changeset{Set: []SecretWrite{{Key: "KEY_002", Value: "first", Location: ".env"}, {Key: "KEY_002", Value: "second", Location: ".env"}}},
+				want:      map[string]string{"KEY_002": "second"},
+				count:     len(set) - 1,
+			},
+			{
+				name:      "delete twice",
+				changeset: Changeset{Delete: []SecretRef{{Key: "KEY_003", Location: ".env"}, {Key: "KEY_003", Location: ".env"}}},
+				want:      map[string]string{"KEY_003": ""},
+				count:     len(set) - 2,
+			},
+			{
+				name:      "recreate deleted",
+				changeset: Changeset{Set: []SecretWrite{{Key: "KEY_001", Value: "back", Location: ".env"}}},
+				want:      map[string]string{"KEY_001": "back"},
+				count:     len(set) - 1,
+			},
+		}
+
+		for _, step := range steps {
+			if err := store.ApplyChangeset(owner, "api", "development", step.changeset); err != nil {
+				t.Fatalf("%s: ApplyChangeset: %v", step.name, err)
+			}
+			secrets, err := store.GetSecrets(owner, "api", "development")
+			if err != nil {
+				t.Fatalf("%s: GetSecrets: %v", step.name, err)
+			}
+			if len(secrets) != step.count {
+				t.Errorf("%s: got %d secrets, want %d", step.name, len(secrets), step.count)
+			}
+			values := secretValues(t, store, owner, "api", "development")
+			for key, want := range step.want {
+				if got := values[key]; got != want {
+					t.Errorf("%s: %s = %q, want %q", step.name, key, got, want)
+				}
+			}
+		}
+
+		history, err := store.SecretHistory(owner, "KEY_001", ".env", "api", "development")
+		if err != nil {
+			t.Fatalf("SecretHistory: %v", err)
+		}
+		if len(history) != 3 || !history[1].Deleted || history[2].Value != "back" {
+			t.Errorf("history of KEY_001 = %+v, want created, deleted and recreated", history)
+		}
+
+		// Other environments are untouched
+		if values := secretValues(t, store, owner, "api", "production"); len(values) != 0 {
+			t.Errorf("production has secrets %v", values)
+		}
+	})
+}
+
+func TestAuthorization(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		owner := newTestUser(t, store, "owner@example.com", false)
+		admin := newTestUser(t, store, "admin@example.com", true)
+		viewer := newTestUser(t, store, "viewer@example.com", false)
+		developer := newTestUser(t, store, "developer@example.com", false)
+		devOnly := newTestUser(t, store, "dev-only@example.com", false)
+		previewOnly := newTestUser(t, store, "preview-only@example.com", false)
+		outsider := newTestUser(t, store, "outsider@example.com", false)
+
+		if err := store.CreateProject(owner, "api"); err != nil {
+			t.Fatalf("CreateProject: %v", err)
+		}
+		if err := store.CreateEnvironment(owner, "api", Environment{Name: "preview", Parent: "production"}); err != nil {
+			t.Fatalf("CreateEnvironment: %v", err)
+		}
+		for _, member := range []struct {
+			user         User
+			role         Role
+			environments []string
+		}{
+			{viewer, RoleViewer, nil},
+			{developer, RoleDeveloper, nil},
+			{devOnly, RoleMaintainer, []string{"development"}},
+			{previewOnly, RoleDeveloper, []string{"preview"}},
+		} {
+			if err := store.AddMember(owner, "api", member.user.Email, member.role, member.environments); err != nil {
+				t.Fatalf("AddMember(%s): %v", member.user.Email, err)
+			}
+		}
+		secret := Changeset{Set: []SecretWrite{{Key: "DATABASE_URL", Value: "postgres://prod", Location: ".env"}}}
+		if err := store.ApplyChangeset(owner, "api", "production", secret); err != nil {
+			t.Fatalf("ApplyChangeset: %v", err)
+		}
+
+		read := func(environmentType string) func(User) error {
+			return func(actor User) error {
+				_, err := store.GetSecrets(actor, "api", environmentType)
+				return err
+			}
+		}
+		write := func(environmentType string) func(User) error {
+			return func(actor User) error {
+				return store.ApplyChangeset(actor, "api", environmentType, Changeset{Set: []SecretWrite{{Key: "NEW", Value: "1", Location: ".env"}}})
+			}
+		}
+
+		tests := []struct {
+			name   string
+			actor  User
+			action func(User) error
+			denied bool
+		}{
+			{"owner reads", owner, read("production"), false},
+			{"global admin reads", admin, read("production"), false},
+			{"outsider reads", outsider, read("development"), true},
+			{"viewer reads", viewer, read("production"), false},
+			{"viewer writes", viewer, write("development"), true},
+			{"developer writes", developer, write("development"), false},
+			{"developer adds a member", developer, func(actor User) error {
+				return store.AddMember(actor, "api", outsider.Email, RoleViewer, nil)
+			}, true},
+			{"restricted member reads its environment", devOnly, read("development"), false},
+			{"restricted member reads another environment", devOnly, read("production"), true},
+			{"restricted member writes another environment", devOnly, write("staging"), true},
+			{"restricted member reads inherited secrets", previewOnly, read("preview"), true},
+			{"restricted member reparents onto another environment", devOnly, func(actor User) error {
+				return store.SetEnvironmentParent(actor, "api", "development", "production")
+			}, true},
+			{"restricted member creates a child of another environment", devOnly, func(actor User) error {
+				return store.CreateEnvironment(actor, "api", Environment{Name: "leak", Parent: "production"})
+			}, true},
+			{"restricted member reparents a restricted environment", devOnly, func(actor User) error {
+				return store.SetEnvironmentParent(actor, "api", "production", "development")
+			}, true},
+			{"restricted member stops its environment inheriting", devOnly, func(actor User) error {
+				return store.SetEnvironmentParent(actor, "api", "development", "")
+			}, false},
+			{"restricted member creates an environment", devOnly, func(actor User) error {
+				return store.CreateEnvironment(actor, "api", Environment{Name: "scratch", Parent: "development"})
+			}, true},
+			{"restricted member deletes another environment", devOnly, func(actor User) error {
+				return store.DeleteEnvironment(actor, "api", "staging", true)
+			}, true},
+			{"developer creates an environment", developer, func(actor User) error {
+				return store.CreateEnvironment(actor, "api", Environment{Name: "scratch"})
+			}, true},
+		}
+
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				err := test.action(test.actor)
+				if test.denied && !errors.Is(err, ErrPermissionDenied) {
+					t.Errorf("got %v, want permission denied", err)
+				}
+				if !test.denied && err != nil {
+					t.Errorf("got %v, want success", err)
+				}
+			})
+		}
+
+		// Nothing of production leaked into the environments of the denied actions
+		if values := secretValues(t, store, owner, "api", "development"); values["DATABASE_URL"] != "" {
+			t.Errorf("development inherits production's DATABASE_URL")
+		}
+		if _, err := store.GetEnvironment("api", "leak"); !errors.Is(err, ErrEnvironmentNotFound) {
+			t.Errorf("GetEnvironment(leak) = %v, want not found", err)
+		}
+		if production, err := store.GetEnvironment("api", "production"); err != nil || production.Parent != "" {
+			t.Errorf("production = %+v, %v, want it to inherit from nothing", production, err)
+		}
+	})
+}
+
+func TestAddMemberRoles(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		owner := newTestUser(t, store, "owner@example.com", false)
+		member := newTestUser(t, store, "member@example.com", false)
+		if err := store.CreateProject(owner, "api"); err != nil {
+			t.Fatalf("CreateProject: %v", err)
+		}
+
+		tests := []struct {
+			role    Role
+			want    Role
+			invalid bool
+		}{
+			{role: "viewer", want: RoleViewer},
+			{role: "Maintainer", want: RoleMaintainer},
+			{role: "owner", invalid: true},
+			{role: "", invalid: true},
+		}
+
+		for _, test := range tests {
+			err := store.AddMember(owner, "api", member.Email, test.role, nil)
+			if test.invalid {
+				if err == nil {
+					t.Errorf("AddMember with role %q succeeded", test.role)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("AddMember with role %q: %v", test.role, err)
+			}
+
+			members, err := store.ListMembers(owner, "api")
+			if err != nil {
+				t.Fatalf("ListMembers: %v", err)
+			}
+			for _, m := range members {
+				if m.User.ID == member.ID && m.Role != test.want {
+					t.Errorf("AddMember with role %q stored %q, want %q", test.role, m.Role, test.want)
+				}
+			}
+		}
+	})
+}
+
+func TestAuditHostname(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		owner := newTestUser(t, store, "owner@example.com", false)
+		if err := store.CreateProject(owner, "api"); err != nil {
+			t.Fatalf("CreateProject: %v", err)
+		}
+
+		// The sbx server sets the hostname of its clients
+		remote := owner
+		remote.Hostname = "laptop.example.com"
+		changeset := Changeset{Set: []SecretWrite{{Key: "A", Value: "1", Location: ".env"}, {Key: "B", Value: "2", Location: ".env"}}}
+		if err := store.ApplyChangeset(remote, "api", "development", changeset); err != nil {
+			t.Fatalf("ApplyChangeset: %v", err)
+		}
+		if _, err := store.GetSecrets(remote, "api", "development"); err != nil {
+			t.Fatalf("GetSecrets: %v", err)
+		}
+
+		events, err := store.ListAuditEvents(owner, AuditFilter{Project: "api", Environment: "development"})
+		if err != nil {
+			t.Fatalf("ListAuditEvents: %v", err)
+		}
+		if len(events) != 3 {
+			t.Fatalf("got %d audit events, want 3", len(events))
+		}
+		for _, event := range events {
+			if event.Hostname != remote.Hostname {
+				t.Errorf("%s event recorded hostname %q, want %q", event.Action, event.Hostname, remote.Hostname)
+			}
+		}
+	})
+}
+
+func TestCompleteKeys(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		owner := newTestUser(t, store, "owner@example.com", false)
+		outsider := newTestUser(t, store, "outsider@example.com", false)
+		if err := store.CreateProject(owner, "api"); err != nil {
+			t.Fatalf("CreateProject: %v", err)
+		}
+		if err := store.CreateSecret(owner, "A", "1", ".", "api", "development"); err != nil {
+			t.Fatalf("CreateSecret: %v", err)
+		}
+
+		filter := AuditFilter{Project: "api", Environment: "development"}
+		before, err := store.ListAuditEvents(owner, filter)
+		if err != nil {
+			t.Fatalf("ListAuditEvents: %v", err)
+		}
+
+		// Completing keys is not audited, unlike listing them
+		keys, err := store.CompleteKeys(owner, "api", "development")
+		if err != nil || len(keys) != 1 || keys[0] != "A" {
+			t.Errorf("CompleteKeys = %v, %v", keys, err)
+		}
+		after, err := store.ListAuditEvents(owner, filter)
+		if err != nil {
+			t.Fatalf("ListAuditEvents: %v", err)
+		}
+		if len(after) != len(before) {
+			t.Errorf("CompleteKeys recorded %d audit events", len(after)-len(before))
+		}
+
+		if _, err := store.CompleteKeys(outsider, "api", "development"); !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("CompleteKeys by a non-member = %v, want permission denied", err)
+		}
+	})
+}
+
+func TestSecretLocations(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		owner := newTestUser(t, store, "owner@example.com", false)
+		if err := store.CreateProject(owner, "api"); err != nil {
+			t.Fatalf("CreateProject: %v", err)
+		}
+
+		tests := []struct {
+			location string
+			invalid  bool
+		}{
+			{location: "."},
+			{location: "services/api"},
+			{location: "./web"},
+			{location: "", invalid: true},
+			{location: "../../../tmp/pwn", invalid: true},
+			{location: "services/../../pwn", invalid: true},
+			{location: "/etc", invalid: true},
+			{location: `..\pwn`, invalid: true},
+		}
+
+		for i, test := range tests {
+			key := fmt.Sprintf("KEY_%d", i)
+			changeset := Changeset{Set: []SecretWrite{{Key: key, Value: "value", Location: test.location}}}
+			errs := map[string]error{
+				"ApplyChangeset": store.ApplyChangeset(owner, "api", "development", changeset),
+				"CreateSecret":   store.CreateSecret(owner, key+"_CREATED", "value", test.location, "api", "development"),
+			}
+			for method, err := range errs {
+				if test.invalid && !errors.Is(err, ErrInvalidRequest) {
+					t.Errorf("%s of location %q = %v, want an invalid request", method, test.location, err)
+				}
+				if !test.invalid && err != nil {
+					t.Errorf("%s rejected location %q: %v", method, test.location, err)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,40 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Timestamps are stored as UTC text in SQLite's CURRENT_TIMESTAMP layout so
+// they sort and compare correctly as strings regardless of the driver.
+const timestampLayout = "2006-01-02 15:04:05"
+
+// formatTimestamp renders a time in the stored timestamp layout
+func formatTimestamp(t time.Time) string {
+	return t.UTC().Format(timestampLayout)
+}
+
+// parseTimestamp parses a timestamp read from the database. Drivers return
+// either the stored text or their own rendering of it, so a few layouts are tried.
+func parseTimestamp(value string) (time.Time, error) {
+	layouts := []string{timestampLayout, time.RFC3339Nano, "2006-01-02 15:04:05Z07:00", "2006-01-02T15:04:05"}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized timestamp %q", value)
+}
+
+// parseNullTimestamp parses a nullable timestamp, returning nil for NULL
+func parseNullTimestamp(value sql.NullString) (*time.Time, error) {
+	if !value.Valid {
+		return nil, nil
+	}
+	t, err := parseTimestamp(value.String)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
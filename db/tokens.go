@@ -0,0 +1,252 @@
+package db
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Service tokens give CI pipelines and other machines access to one project,
+// optionally to a single environment of it, without a user account. A token
+// acts on behalf of the user who created it and can never do more than that
+// user currently may, nor more than its scope: reading, or reading and
+// writing secrets. Like sessions, only a hash of each token is stored.
+
+// ServiceTokenPrefix starts every service token, telling them apart from
+// session tokens
+const ServiceTokenPrefix = "sbx_"
+
+// ServiceToken describes a service token; the token itself is only returned
+// when it is created
+type ServiceToken struct {
+	ID          int        `json:"id"`
+	Name        string     `json:"name"`
+	Project     string     `json:"project"`
+	Environment string     `json:"environment"` // Empty when the token may access every environment
+	ReadOnly    bool       `json:"read_only"`
+	CreatedBy   User       `json:"created_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+}
+
+// Label names the token in listings and the audit log
+func (t ServiceToken) Label() string {
+	if t.Name != "" {
+		return t.Name
+	}
+	return fmt.Sprintf("#%d", t.ID)
+}
+
+// authorizeToken checks that a permission falls within a token's scope
+func authorizeToken(token *ServiceToken, projectName, environmentType string, perm Permission) error {
+	if projectName != token.Project {
+		return fmt.Errorf("%w: token %s is limited to project '%s'", ErrPermissionDenied, token.Label(), token.Project)
+	}
+	if token.Environment != "" && environmentType != "" && environmentType != token.Environment {
+		return fmt.Errorf("%w: token %s is limited to the %s environment", ErrPermissionDenied, token.Label(), token.Environment)
+	}
+
+	allowed := PermWrite
+	if token.ReadOnly {
+		allowed = PermRead
+	}
+	if perm > allowed {
+		return fmt.Errorf("%w: token %s cannot %s project '%s'", ErrPermissionDenied, token.Label(), perm, projectName)
+	}
+	return nil
+}
+
+// CreateServiceToken creates a token for a project, limited to one
+// environment unless environmentType is empty, and returns it along with its
+// description. Creating tokens requires maintaining the project.
+func (db *sqlStore) CreateServiceToken(actor User, projectName, environmentType, name string, readOnly bool, ttl time.Duration) (string, ServiceToken, error) {
+	if err := authorize(db, actor, projectName, environmentType, PermMaintain); err != nil {
+		return "", ServiceToken{}, err
+	}
+	if ttl <= 0 {
+		return "", ServiceToken{}, invalidf("a service token must expire, the TTL must be positive")
+	}
+
+	var projectID int
+	err := db.QueryRow("SELECT id FROM projects WHERE name = ?", projectName).Scan(&projectID)
+	if err != nil {
+		return "", ServiceToken{}, fmt.Errorf("error fetching project: %v", err)
+	}
+
+	var environmentID *int
+	if environmentType != "" {
+		env, err := db.GetEnvironment(projectName, environmentType)
+		if err != nil {
+			return "", ServiceToken{}, err
+		}
+		environmentID = &env.ID
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", ServiceToken{}, fmt.Errorf("error generating service token: %v", err)
+	}
+	token := ServiceTokenPrefix + hex.EncodeToString(raw)
+
+	now := time.Now().UTC()
+	info := ServiceToken{
+		Name:        name,
+		Project:     projectName,
+		Environment: environmentType,
+		ReadOnly:    readOnly,
+		CreatedBy:   User{ID: actor.ID, Email: actor.Email, Admin: actor.Admin},
+		CreatedAt:   now.Truncate(time.Second),
+		ExpiresAt:   now.Add(ttl).Truncate(time.Second),
+	}
+
+	query := `
+		INSERT INTO service_tokens (name, token_hash, project_id, environment_id, read_only, created_by, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id`
+	err = db.QueryRow(query, name, hashToken(token), projectID, environmentID, readOnly, actor.ID,
+		formatTimestamp(info.CreatedAt), formatTimestamp(info.ExpiresAt)).Scan(&info.ID)
+	if err != nil {
+		return "", ServiceToken{}, fmt.Errorf("error creating service token: %v", err)
+	}
+
+	if err := recordAudit(db, actor, projectName, environmentType, info.Label(), AuditCreateToken); err != nil {
+		return "", ServiceToken{}, err
+	}
+	return token, info, nil
+}
+
+// serviceTokenColumns are selected by scanServiceToken
+const serviceTokenColumns = `
+	t.id, t.name, p.name, COALESCE(e.environment_type, ''), t.read_only,
+	u.id, u.email, u.admin, t.created_at, t.expires_at, t.revoked_at, t.last_used_at`
+
+// serviceTokenJoins joins the tables serviceTokenColumns come from
+const serviceTokenJoins = `
+	FROM service_tokens t
+	INNER JOIN projects p ON t.project_id = p.id
+	INNER JOIN users u ON t.created_by = u.id
+	LEFT JOIN environments e ON t.environment_id = e.id`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanServiceToken reads a row selected with serviceTokenColumns
+func scanServiceToken(row rowScanner) (ServiceToken, error) {
+	var token ServiceToken
+	var createdAtStr, expiresAtStr string
+	var revokedAtStr, lastUsedAtStr sql.NullString
+	err := row.Scan(&token.ID, &token.Name, &token.Project, &token.Environment, &token.ReadOnly,
+		&token.CreatedBy.ID, &token.CreatedBy.Email, &token.CreatedBy.Admin,
+		&createdAtStr, &expiresAtStr, &revokedAtStr, &lastUsedAtStr)
+	if err != nil {
+		return ServiceToken{}, err
+	}
+
+	if token.CreatedAt, err = parseTimestamp(createdAtStr); err != nil {
+		return ServiceToken{}, err
+	}
+	if token.ExpiresAt, err = parseTimestamp(expiresAtStr); err != nil {
+		return ServiceToken{}, err
+	}
+	if token.RevokedAt, err = parseNullTimestamp(revokedAtStr); err != nil {
+		return ServiceToken{}, err
+	}
+	if token.LastUsedAt, err = parseNullTimestamp(lastUsedAtStr); err != nil {
+		return ServiceToken{}, err
+	}
+	return token, nil
+}
+
+// ListServiceTokens returns the tokens of a project, newest first, including
+// revoked and expired ones
+func (db *sqlStore) ListServiceTokens(actor User, projectName string) ([]ServiceToken, error) {
+	if err := authorize(db, actor, projectName, "", PermMaintain); err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + serviceTokenColumns + serviceTokenJoins + `
+		WHERE p.name = ?
+		ORDER BY t.created_at DESC, t.id DESC`
+	rows, err := db.Query(query, projectName)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching service tokens: %v", err)
+	}
+	defer rows.Close()
+
+	var tokens []ServiceToken
+	for rows.Next() {
+		token, err := scanServiceToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeServiceToken revokes a token of a project by ID. Revoked tokens are
+// kept so they still show up in listings.
+func (db *sqlStore) RevokeServiceToken(actor User, projectName string, id int) error {
+	if err := authorize(db, actor, projectName, "", PermMaintain); err != nil {
+		return err
+	}
+
+	query := `SELECT ` + serviceTokenColumns + serviceTokenJoins + `
+		WHERE p.name = ? AND t.id = ?`
+	token, err := scanServiceToken(db.QueryRow(query, projectName, id))
+	if err == sql.ErrNoRows {
+		return invalidf("project '%s' has no service token %d", projectName, id)
+	}
+	if err != nil {
+		return fmt.Errorf("error fetching service token: %v", err)
+	}
+	if token.RevokedAt != nil {
+		return invalidf("service token %s is already revoked", token.Label())
+	}
+
+	_, err = db.Exec("UPDATE service_tokens SET revoked_at = ? WHERE id = ?", formatTimestamp(time.Now()), id)
+	if err != nil {
+		return fmt.Errorf("error revoking service token: %v", err)
+	}
+
+	return recordAudit(db, actor, projectName, token.Environment, token.Label(), AuditRevokeToken)
+}
+
+// serviceTokenUser returns the actor of a valid service token: its creator,
+// limited to the token's scope. The actor is never an admin, so a token can't
+// be used for admin-only operations that don't go through authorize.
+func (db *sqlStore) serviceTokenUser(secret string) (User, error) {
+	now := formatTimestamp(time.Now())
+	query := `SELECT ` + serviceTokenColumns + serviceTokenJoins + `
+		WHERE t.token_hash = ? AND t.revoked_at IS NULL AND t.expires_at > ?`
+	token, err := scanServiceToken(db.QueryRow(query, hashToken(secret), now))
+	if err == sql.ErrNoRows {
+		return User{}, ErrInvalidToken
+	}
+	if err != nil {
+		return User{}, fmt.Errorf("error fetching service token: %v", err)
+	}
+
+	if _, err := db.Exec("UPDATE service_tokens SET last_used_at = ? WHERE id = ?", now, token.ID); err != nil {
+		return User{}, fmt.Errorf("error updating service token: %v", err)
+	}
+
+	return User{
+		ID:    token.CreatedBy.ID,
+		Email: fmt.Sprintf("%s (token %s)", token.CreatedBy.Email, token.Label()),
+		Token: &token,
+	}, nil
+}
+
+// isServiceToken reports whether a bearer token is a service token rather
+// than a session token
+func isServiceToken(token string) bool {
+	return strings.HasPrefix(token, ServiceTokenPrefix)
+}
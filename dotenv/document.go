@@ -0,0 +1,120 @@
+package dotenv
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+// Document is a parsed .env file that can be edited without losing anything
+// that was not changed: comments, blank lines, ordering and the exact
+// formatting of untouched definitions are written back as they were read.
+type Document struct {
+	nodes []node
+	// crlf is set for files with Windows line endings, which are parsed as
+	// "\n" and written back as "\r\n"
+	crlf bool
+}
+
+// node is either verbatim text between definitions or a single definition
+type node struct {
+	text  string
+	entry *Entry
+}
+
+// ParseDocument reads a .env file for editing
+func ParseDocument(r io.Reader) (*Document, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{src: strings.ReplaceAll(string(data), "\r\n", "\n"), line: 1}
+	doc := &Document{crlf: usesCRLF(data)}
+	for {
+		start := p.pos
+		p.skipBlankAndComments()
+		if p.pos > start {
+			doc.nodes = append(doc.nodes, node{text: p.src[start:p.pos]})
+		}
+		if p.done() {
+			return doc, nil
+		}
+
+		start = p.pos
+		entry, err := p.entry()
+		if err != nil {
+			return nil, err
+		}
+		doc.nodes = append(doc.nodes, node{text: p.src[start:p.pos], entry: &entry})
+	}
+}
+
+// Entries returns the definitions of the document in order
+func (d *Document) Entries() []Entry {
+	var entries []Entry
+	for _, n := range d.nodes {
+		if n.entry != nil {
+			entries = append(entries, *n.entry)
+		}
+	}
+	return entries
+}
+
+// Get returns the definition of a key. When a key is defined more than once
+// the last definition wins, as it does when the file is loaded.
+func (d *Document) Get(key string) (Entry, bool) {
+	if i := d.index(key); i >= 0 {
+		return *d.nodes[i].entry, true
+	}
+	return Entry{}, false
+}
+
+// Set changes the value of a key in place, keeping its export prefix and
+// inline comment, or appends a new definition when the key is not defined
+func (d *Document) Set(key, value string) {
+	i := d.index(key)
+	if i < 0 {
+		if len(d.nodes) > 0 && !strings.HasSuffix(d.nodes[len(d.nodes)-1].text, "\n") {
+			d.nodes = append(d.nodes, node{text: "\n"})
+		}
+		entry := Entry{Key: key, Value: value}
+		entry.Raw = format(entry)
+		d.nodes = append(d.nodes, node{text: entry.Raw + "\n", entry: &entry})
+		return
+	}
+
+	entry := *d.nodes[i].entry
+	entry.Value = value
+	entry.Raw = format(entry)
+	d.nodes[i] = node{text: entry.Raw, entry: &entry}
+}
+
+// Bytes returns the content of the document, with the line endings of the
+// file it was read from
+func (d *Document) Bytes() []byte {
+	var buf bytes.Buffer
+	for _, n := range d.nodes {
+		buf.WriteString(n.text)
+	}
+	if d.crlf {
+		return bytes.ReplaceAll(buf.Bytes(), []byte("\n"), []byte("\r\n"))
+	}
+	return buf.Bytes()
+}
+
+// usesCRLF reports whether a file ends its lines with "\r\n", judging by the
+// first line
+func usesCRLF(data []byte) bool {
+	i := bytes.IndexByte(data, '\n')
+	return i > 0 && data[i-1] == '\r'
+}
+
+func (d *Document) index(key string) int {
+	for i := len(d.nodes) - 1; i >= 0; i-- {
+		if d.nodes[i].entry != nil && d.nodes[i].entry.Key == key {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,294 @@
+// Package dotenv reads and writes .env files.
+//
+// The accepted syntax follows the common dotenv conventions:
+//
+//	# full line comment
+//	KEY=value                  unquoted, ends at the line end or at " #"
+//	export KEY=value           the export prefix is ignored
+//	KEY='literal $value'       single quotes keep everything literal
+//	KEY="line one\nline two"   double quotes support \n \r \t \" \\ and \$
+//	KEY="spans
+//	several lines"             quoted values may contain line breaks
+//	KEY=value # inline comment
+//
+// Variables are not expanded. Writing quotes values only when needed, so any
+// value written is read back unchanged.
+package dotenv
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Entry is a single KEY=value definition
+type Entry struct {
+	Key     string
+	Value   string
+	Export  bool   // The definition was prefixed with export
+	Comment string // Inline comment after the value, without the leading #
+	Line    int    // Line the definition starts on
+	Raw     string // The definition as it appears in the source, including its comment
+}
+
+// Parse reads every definition of a .env file in order. Keys defined more
+// than once are returned once per definition.
+func Parse(r io.Reader) ([]Entry, error) {
+	doc, err := ParseDocument(r)
+	if err != nil {
+		return nil, err
+	}
+	return doc.Entries(), nil
+}
+
+// ParseFile reads every definition of the .env file at path
+func ParseFile(path string) ([]Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries, err := Parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return entries, nil
+}
+
+type parser struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *parser) peek() byte {
+	if p.done() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *parser) advance() byte {
+	c := p.src[p.pos]
+	p.pos++
+	if c == '\n' {
+		p.line++
+	}
+	return c
+}
+
+func (p *parser) skipSpaces() {
+	for !p.done() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+// skipToLineEnd moves to the end of the current line, before its line break
+func (p *parser) skipToLineEnd() string {
+	start := p.pos
+	for !p.done() && p.peek() != '\n' {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *parser) skipBlankAndComments() {
+	for !p.done() {
+		switch p.peek() {
+		case ' ', '\t', '\n':
+			p.advance()
+		case '#':
+			p.skipToLineEnd()
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+func isKeyChar(c byte) bool {
+	return c == '_' || c == '.' || c == '-' ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func (p *parser) key() string {
+	start := p.pos
+	for !p.done() && isKeyChar(p.peek()) {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *parser) entry() (Entry, error) {
+	entry := Entry{Line: p.line}
+	start := p.pos
+
+	entry.Key = p.key()
+	if entry.Key == "export" && (p.peek() == ' ' || p.peek() == '\t') {
+		p.skipSpaces()
+		entry.Export = true
+		entry.Key = p.key()
+	}
+	if entry.Key == "" {
+		return Entry{}, p.errorf("expected a key, found %q", p.skipToLineEnd())
+	}
+
+	p.skipSpaces()
+	if p.peek() != '=' {
+		return Entry{}, p.errorf("expected '=' after %s", entry.Key)
+	}
+	p.pos++
+	p.skipSpaces()
+
+	var err error
+	quoted := true
+	switch p.peek() {
+	case '\'':
+		entry.Value, err = p.singleQuoted()
+	case '"':
+		entry.Value, err = p.doubleQuoted()
+	default:
+		quoted = false
+		entry.Value, entry.Comment = unquoted(p.skipToLineEnd())
+	}
+	if err != nil {
+		return Entry{}, err
+	}
+
+	if quoted {
+		// Only a comment may follow a quoted value
+		p.skipSpaces()
+		switch {
+		case p.peek() == '#':
+			entry.Comment = strings.TrimSpace(p.skipToLineEnd()[1:])
+		case !p.done() && p.peek() != '\n':
+			return Entry{}, p.errorf("unexpected %q after the quoted value of %s", p.skipToLineEnd(), entry.Key)
+		}
+	}
+
+	entry.Raw = strings.TrimRight(p.src[start:p.pos], " \t")
+	return entry, nil
+}
+
+// unquoted splits an unquoted value from its inline comment. A # only starts
+// a comment at the beginning of the value or after whitespace, so values such
+// as passwords and URL fragments may contain it.
+func unquoted(text string) (string, string) {
+	for i := 0; i < len(text); i++ {
+		if text[i] == '#' && (i == 0 || text[i-1] == ' ' || text[i-1] == '\t') {
+			return strings.TrimRight(text[:i], " \t"), strings.TrimSpace(text[i+1:])
+		}
+	}
+	return strings.TrimRight(text, " \t"), ""
+}
+
+func (p *parser) singleQuoted() (string, error) {
+	line := p.line
+	p.advance()
+	start := p.pos
+	for !p.done() {
+		if p.peek() == '\'' {
+			value := p.src[start:p.pos]
+			p.advance()
+			return value, nil
+		}
+		p.advance()
+	}
+	return "", fmt.Errorf("line %d: unterminated single quoted value", line)
+}
+
+func (p *parser) doubleQuoted() (string, error) {
+	line := p.line
+	p.advance()
+	var value strings.Builder
+	for !p.done() {
+		c := p.advance()
+		switch c {
+		case '"':
+			return value.String(), nil
+		case '\\':
+			if p.done() {
+				continue
+			}
+			escaped := p.advance()
+			switch escaped {
+			case 'n':
+				value.WriteByte('\n')
+			case 'r':
+				value.WriteByte('\r')
+			case 't':
+				value.WriteByte('\t')
+			case '"', '\\', '$', '`':
+				value.WriteByte(escaped)
+			default:
+				// Unknown escapes are kept as written
+				value.WriteByte('\\')
+				value.WriteByte(escaped)
+			}
+		default:
+			value.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("line %d: unterminated double quoted value", line)
+}
+
+// Quote returns value in the form it is written to a .env file: unchanged
+// when it is safe unquoted, double quoted with escapes otherwise
+func Quote(value string) string {
+	if !needsQuotes(value) {
+		return value
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "$", `\$`, "`", "\\`")
+	return `"` + replacer.Replace(value) + `"`
+}
+
+func needsQuotes(value string) bool {
+	if value == "" {
+		return false
+	}
+	if strings.ContainsAny(value, "\n\r\"'\\$`") {
+		return true
+	}
+	// Surrounding whitespace would be trimmed, and a # after whitespace starts a comment
+	if strings.TrimSpace(value) != value || value[0] == '#' {
+		return true
+	}
+	return strings.Contains(value, " #") || strings.Contains(value, "\t#")
+}
+
+// Line formats a single KEY=value definition
+func Line(key, value string) string {
+	return key + "=" + Quote(value)
+}
+
+// Write writes entries as .env definitions, one per line, keeping their
+// export prefixes and inline comments
+func Write(w io.Writer, entries []Entry) error {
+	for _, entry := range entries {
+		if _, err := fmt.Fprintln(w, format(entry)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// format formats a definition with its export prefix and inline comment
+func format(entry Entry) string {
+	line := Line(entry.Key, entry.Value)
+	if entry.Export {
+		line = "export " + line
+	}
+	if entry.Comment != "" {
+		line += " # " + entry.Comment
+	}
+	return line
+}
@@ -0,0 +1,229 @@
+package dotenv
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []Entry
+	}{
+		{
+			name:  "unquoted",
+			input: "KEY=value\nOTHER = spaced value  \n",
+			want:  []Entry{{Key: "KEY", Value: "value"}, {Key: "OTHER", Value: "spaced value"}},
+		},
+		{
+			name:  "empty value",
+			input: "EMPTY=\nQUOTED=\"\"\n",
+			want:  []Entry{{Key: "EMPTY", Value: ""}, {Key: "QUOTED", Value: ""}},
+		},
+		{
+			name:  "comments",
+			input: "# a comment\n\nKEY=value # inline\nHASH=pass#word\n  # indented comment\n",
+			want:  []Entry{{Key: "KEY", Value: "value", Comment: "inline"}, {Key: "HASH", Value: "pass#word"}},
+		},
+		{
+			name:  "export",
+			input: "export KEY=value\nexport\tTAB=1\nexport=plain\n",
+			want:  []Entry{{Key: "KEY", Value: "value", Export: true}, {Key: "TAB", Value: "1", Export: true}, {Key: "export", Value: "plain"}},
+		},
+		{
+			name:  "single quotes",
+			input: `KEY='literal $value \n # not a comment' # comment` + "\n",
+			want:  []Entry{{Key: "KEY", Value: `literal $value \n # not a comment`, Comment: "comment"}},
+		},
+		{
+			name:  "double quote escapes",
+			input: `KEY="a\nb\tc\r\"d\" \\ \$HOME \` + "`" + ` \q"` + "\n",
+			want:  []Entry{{Key: "KEY", Value: "a\nb\tc\r\"d\" \\ $HOME ` \\q"}},
+		},
+		{
+			name:  "multiline",
+			input: "CERT=\"-----BEGIN-----\nabc\n-----END-----\"\nSINGLE='one\ntwo'\nAFTER=1\n",
+			want:  []Entry{{Key: "CERT", Value: "-----BEGIN-----\nabc\n-----END-----"}, {Key: "SINGLE", Value: "one\ntwo"}, {Key: "AFTER", Value: "1"}},
+		},
+		{
+			name:  "crlf",
+			input: "KEY=value\r\nexport QUOTED=\"a b\" # note\r\nMULTI=\"one\r\ntwo\"\r\n",
+			want:  []Entry{{Key: "KEY", Value: "value"}, {Key: "QUOTED", Value: "a b", Export: true, Comment: "note"}, {Key: "MULTI", Value: "one\ntwo"}},
+		},
+		{
+			name:  "duplicate keys",
+			input: "KEY=1\nKEY=2\n",
+			want:  []Entry{{Key: "KEY", Value: "1"}, {Key: "KEY", Value: "2"}},
+		},
+		{
+			name:  "no trailing newline",
+			input: "KEY=value",
+			want:  []Entry{{Key: "KEY", Value: "value"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entries, err := Parse(strings.NewReader(test.input))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if len(entries) != len(test.want) {
+				t.Fatalf("got %d entries, want %d: %+v", len(entries), len(test.want), entries)
+			}
+			for i, want := range test.want {
+				got := entries[i]
+				if got.Key != want.Key || got.Value != want.Value || got.Export != want.Export || got.Comment != want.Comment {
+					t.Errorf("entry %d = {%q %q export=%v comment=%q}, want {%q %q export=%v comment=%q}",
+						i, got.Key, got.Value, got.Export, got.Comment, want.Key, want.Value, want.Export, want.Comment)
+				}
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"missing equals", "KEY value\n", "line 1: expected '=' after KEY"},
+		{"missing key", "=value\n", "line 1: expected a key"},
+		{"unterminated double quote", "A=1\nKEY=\"open\n", "line 2: unterminated double quoted value"},
+		{"unterminated single quote", "KEY='open\n", "line 1: unterminated single quoted value"},
+		{"text after quotes", "KEY=\"a\" b\n", "line 1: unexpected"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(test.input))
+			if err == nil {
+				t.Fatalf("Parse succeeded, want an error containing %q", test.want)
+			}
+			if !strings.Contains(err.Error(), test.want) {
+				t.Errorf("error %q does not contain %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestQuoteRoundTrip(t *testing.T) {
+	values := []string{
+		"",
+		"plain",
+		"with space",
+		" leading and trailing ",
+		"#starts-with-hash",
+		"pass#word",
+		"value # not a comment",
+		"tab\t# not a comment",
+		"line one\nline two",
+		"crlf\r\nvalue",
+		`double "quotes"`,
+		"single 'quotes'",
+		`back\slash`,
+		"$HOME and ${PATH}",
+		"`command`",
+		"unicode ✓ é",
+		"=equals=",
+	}
+
+	for _, value := range values {
+		line := Line("KEY", value)
+		entries, err := Parse(strings.NewReader(line + "\n"))
+		if err != nil {
+			t.Errorf("Parse(%q): %v", line, err)
+			continue
+		}
+		if len(entries) != 1 || entries[0].Value != value {
+			t.Errorf("value %q was written as %q and read back as %+v", value, line, entries)
+		}
+	}
+}
+
+func TestQuoteOnlyWhenNeeded(t *testing.T) {
+	tests := map[string]string{
+		"plain":      "plain",
+		"with space": "with space",
+		"a\nb":       `"a\nb"`,
+		"$VAR":       `"\$VAR"`,
+		" padded":    `" padded"`,
+	}
+	for value, want := range tests {
+		if got := Quote(value); got != want {
+			t.Errorf("Quote(%q) = %s, want %s", value, got, want)
+		}
+	}
+}
+
+func TestWriteRoundTrip(t *testing.T) {
+	entries := []Entry{
+		{Key: "PLAIN", Value: "value"},
+		{Key: "EXPORTED", Value: "exported value", Export: true},
+		{Key: "COMMENTED", Value: "x", Comment: "explained"},
+		{Key: "MULTILINE", Value: "one\ntwo", Export: true, Comment: "both"},
+		{Key: "EMPTY", Value: ""},
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, entries); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	parsed, err := Parse(&buf)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(parsed) != len(entries) {
+		t.Fatalf("got %d entries back, want %d", len(parsed), len(entries))
+	}
+	for i, want := range entries {
+		got := parsed[i]
+		if got.Key != want.Key || got.Value != want.Value || got.Export != want.Export || got.Comment != want.Comment {
+			t.Errorf("entry %d = %+v, want %+v", i, got, want)
+		}
+	}
+}
+
+func TestDocumentPreservesUntouchedContent(t *testing.T) {
+	input := "# header\n\nexport A=1 # keep\nB = 'raw'\n\n# trailer\n"
+	doc, err := ParseDocument(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseDocument: %v", err)
+	}
+	if got := string(doc.Bytes()); got != input {
+		t.Errorf("unchanged document = %q, want %q", got, input)
+	}
+
+	doc.Set("A", "two words")
+	doc.Set("C", "new")
+	want := "# header\n\nexport A=two words # keep\nB = 'raw'\n\n# trailer\nC=new\n"
+	if got := string(doc.Bytes()); got != want {
+		t.Errorf("edited document = %q, want %q", got, want)
+	}
+
+	if entry, ok := doc.Get("B"); !ok || entry.Value != "raw" {
+		t.Errorf("Get(B) = %+v, %v", entry, ok)
+	}
+}
+
+func TestDocumentKeepsCRLF(t *testing.T) {
+	input := "# header\r\nA=1\r\nB=\"one\r\ntwo\"\r\n"
+	doc, err := ParseDocument(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseDocument: %v", err)
+	}
+	if got := string(doc.Bytes()); got != input {
+		t.Errorf("unchanged document = %q, want %q", got, input)
+	}
+
+	doc.Set("A", "2")
+	doc.Set("C", "new")
+	want := "# header\r\nA=2\r\nB=\"one\r\ntwo\"\r\nC=new\r\n"
+	if got := string(doc.Bytes()); got != want {
+		t.Errorf("edited document = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,73 @@
+package format
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	dbpkg "github.com/spf13/sbx/db"
+)
+
+// Meta describes where a set of secrets comes from. Formats that produce named
+// resources, such as Kubernetes secrets, derive their names from it.
+type Meta struct {
+	Project     string
+	Environment string
+}
+
+// Formatter renders secrets in a specific file format
+type Formatter interface {
+	Format(w io.Writer, meta Meta, secrets []dbpkg.Secret) error
+}
+
+// FormatterFunc adapts a function to the Formatter interface
+type FormatterFunc func(w io.Writer, meta Meta, secrets []dbpkg.Secret) error
+
+// Format calls f(w, meta, secrets)
+func (f FormatterFunc) Format(w io.Writer, meta Meta, secrets []dbpkg.Secret) error {
+	return f(w, meta, secrets)
+}
+
+var (
+	formatters = make(map[string]Formatter)
+	aliases    = make(map[string]string)
+)
+
+// Register makes a formatter available under a name and optional aliases.
+// Formats register themselves from init.
+func Register(name string, f Formatter, alias ...string) {
+	formatters[name] = f
+	for _, a := range alias {
+		aliases[a] = name
+	}
+}
+
+// Get returns the formatter registered under a name or alias
+func Get(name string) (Formatter, error) {
+	if target, ok := aliases[name]; ok {
+		name = target
+	}
+	f, ok := formatters[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown format '%s' (available formats: %s)", name, strings.Join(Names(), ", "))
+	}
+	return f, nil
+}
+
+// Names returns the names of all registered formats in alphabetical order
+func Names() []string {
+	var names []string
+	for name := range formatters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sorted returns the secrets ordered by key, so output is stable between runs
+func sorted(secrets []dbpkg.Secret) []dbpkg.Secret {
+	result := append([]dbpkg.Secret(nil), secrets...)
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result
+}
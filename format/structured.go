@@ -0,0 +1,110 @@
+package format
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	dbpkg "github.com/spf13/sbx/db"
+)
+
+func init() {
+	Register("json", FormatterFunc(formatJSON))
+	Register("yaml", FormatterFunc(formatYAML), "yml")
+	Register("k8s-secret", FormatterFunc(formatKubernetesSecret), "k8s", "kubernetes")
+}
+
+// keyValues returns the secrets as a key to value map
+func keyValues(secrets []dbpkg.Secret) map[string]string {
+	values := make(map[string]string)
+	for _, secret := range secrets {
+		values[secret.Key] = secret.Value
+	}
+	return values
+}
+
+// formatJSON writes a single JSON object mapping keys to values
+func formatJSON(w io.Writer, meta Meta, secrets []dbpkg.Secret) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(keyValues(secrets))
+}
+
+// formatYAML writes a YAML mapping of keys to values
+func formatYAML(w io.Writer, meta Meta, secrets []dbpkg.Secret) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(keyValues(secrets)); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// kubernetesSecret is the manifest of a Kubernetes Secret
+type kubernetesSecret struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   kubernetesMeta    `yaml:"metadata"`
+	Type       string            `yaml:"type"`
+	Data       map[string]string `yaml:"data"`
+}
+
+type kubernetesMeta struct {
+	Name   string            `yaml:"name"`
+	Labels map[string]string `yaml:"labels,omitempty"`
+}
+
+var (
+	kubernetesKeyPattern     = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+	kubernetesInvalidPattern = regexp.MustCompile(`[^a-z0-9-]+`)
+)
+
+// formatKubernetesSecret writes an Opaque Secret manifest named after the
+// project and environment, ready for kubectl apply -f -
+func formatKubernetesSecret(w io.Writer, meta Meta, secrets []dbpkg.Secret) error {
+	data := make(map[string]string)
+	for _, secret := range secrets {
+		if !kubernetesKeyPattern.MatchString(secret.Key) {
+			return fmt.Errorf("%s is not a valid Kubernetes secret key", secret.Key)
+		}
+		data[secret.Key] = base64.StdEncoding.EncodeToString([]byte(secret.Value))
+	}
+
+	manifest := kubernetesSecret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata: kubernetesMeta{
+			Name: kubernetesName(meta.Project + "-" + meta.Environment),
+			Labels: map[string]string{
+				"app.kubernetes.io/managed-by": "sbx",
+			},
+		},
+		Type: "Opaque",
+		Data: data,
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(manifest); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// kubernetesName turns a name into a valid DNS-1123 subdomain
+func kubernetesName(name string) string {
+	name = kubernetesInvalidPattern.ReplaceAllString(strings.ToLower(name), "-")
+	name = strings.Trim(name, "-")
+	if len(name) > 253 {
+		name = strings.TrimRight(name[:253], "-")
+	}
+	if name == "" {
+		name = "sbx-secrets"
+	}
+	return name
+}
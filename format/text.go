@@ -0,0 +1,93 @@
+package format
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	dbpkg "github.com/spf13/sbx/db"
+	"github.com/spf13/sbx/dotenv"
+)
+
+func init() {
+	Register("dotenv", FormatterFunc(formatDotenv), "env")
+	Register("shell-export", FormatterFunc(formatShellExport), "shell")
+	Register("docker-env", FormatterFunc(formatDockerEnv), "docker")
+	Register("systemd", FormatterFunc(formatSystemd))
+}
+
+// formatDotenv writes KEY=value lines, quoting values only where needed
+func formatDotenv(w io.Writer, meta Meta, secrets []dbpkg.Secret) error {
+	for _, secret := range sorted(secrets) {
+		if _, err := fmt.Fprintln(w, dotenv.Line(secret.Key, secret.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatShellExport writes export statements for POSIX shells, suitable for eval
+func formatShellExport(w io.Writer, meta Meta, secrets []dbpkg.Secret) error {
+	for _, secret := range sorted(secrets) {
+		if !isShellName(secret.Key) {
+			return fmt.Errorf("%s is not a valid shell variable name", secret.Key)
+		}
+		// Single quotes keep everything literal; a quote itself ends the string,
+		// is escaped, and a new string begins
+		value := "'" + strings.ReplaceAll(secret.Value, "'", `'\''`) + "'"
+		if _, err := fmt.Fprintf(w, "export %s=%s\n", secret.Key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatDockerEnv writes a file for docker run --env-file. Docker takes every
+// value literally up to the end of the line, so nothing is quoted.
+func formatDockerEnv(w io.Writer, meta Meta, secrets []dbpkg.Secret) error {
+	for _, secret := range sorted(secrets) {
+		if strings.ContainsAny(secret.Value, "\r\n") {
+			return fmt.Errorf("%s contains a line break, which docker env files cannot represent", secret.Key)
+		}
+		if _, err := fmt.Fprintf(w, "%s=%s\n", secret.Key, secret.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatSystemd writes a file for the EnvironmentFile= setting of a systemd unit
+func formatSystemd(w io.Writer, meta Meta, secrets []dbpkg.Secret) error {
+	for _, secret := range sorted(secrets) {
+		if strings.ContainsAny(secret.Value, "\r\n") {
+			return fmt.Errorf("%s contains a line break, which systemd environment files cannot represent", secret.Key)
+		}
+		if _, err := fmt.Fprintf(w, "%s=%s\n", secret.Key, doubleQuote(secret.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// doubleQuote wraps a value in double quotes, escaping the characters that
+// are special inside them
+func doubleQuote(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "$", `\$`, "`", "\\`")
+	return `"` + replacer.Replace(value) + `"`
+}
+
+// isShellName reports whether name is a valid POSIX shell variable name
+func isShellName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
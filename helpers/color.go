@@ -0,0 +1,24 @@
+package helpers
+
+import (
+	"os"
+
+	"golang.org/x/term"
+)
+
+// ANSI color codes used for terminal output
+const (
+	Red    = "\033[31m"
+	Green  = "\033[32m"
+	Yellow = "\033[33m"
+	reset  = "\033[0m"
+)
+
+// Colorize wraps text in the given color when stdout is a terminal and
+// NO_COLOR is not set
+func Colorize(color, text string) string {
+	if os.Getenv("NO_COLOR") != "" || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return text
+	}
+	return color + text + reset
+}
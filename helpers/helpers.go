@@ -1,23 +1,92 @@
 package helpers
 
 import (
+	"bufio"
 	"fmt"
 	"os"
-	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
 )
 
-func CheckIfStarted(started bool) {
-	if !started {
-		fmt.Println("You must start sbx with the 'start' command before using any other commands.")
-		os.Exit(1)
+// stdin buffers the answers read by Ask. It is shared by every call, as a
+// reader of its own would swallow the answers to later prompts when several
+// are piped in at once.
+var stdin = bufio.NewReader(os.Stdin)
+
+// PromptPassword asks for a password on the terminal without echoing it
+func PromptPassword(prompt string) (string, error) {
+	fmt.Print(prompt)
+	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %v", err)
 	}
+	return string(password), nil
 }
 
-// GetCurrentDirName returns the name of the current directory
-func GetCurrentDirName() (string, error) {
-	dir, err := os.Getwd()
+// ParseDuration parses a Go duration, additionally accepting a number of days such as "30d"
+func ParseDuration(value string) (time.Duration, error) {
+	if days, found := strings.CutSuffix(value, "d"); found {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(value)
 	if err != nil {
-		return "", fmt.Errorf("failed to get current directory: %v", err)
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return d, nil
+}
+
+// ParseTime parses an absolute time (RFC 3339, "2006-01-02 15:04" or "2006-01-02")
+// or a duration relative to now such as "24h" or "7d", meaning that long ago
+func ParseTime(value string) (time.Time, error) {
+	layouts := []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	if d, err := ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (use RFC 3339, YYYY-MM-DD or a duration such as 24h or 7d)", value)
+}
+
+// MaskValue hides a secret value for display. Every value gets the same mask:
+// even a few leading characters of a token can be a real part of its entropy.
+func MaskValue(value string) string {
+	if value == "" {
+		return ""
+	}
+	return "********"
+}
+
+// IsInteractive reports whether stdin is a terminal a user can answer prompts on
+func IsInteractive() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// Confirm asks a yes/no question on the terminal, defaulting to no
+func Confirm(prompt string) bool {
+	answer := Ask(prompt + " [y/N]")
+	return answer == "y" || answer == "yes"
+}
+
+// Ask prints a prompt and returns the answer typed on the terminal, trimmed
+// and lowercased. It returns an empty answer when nothing can be read.
+func Ask(prompt string) string {
+	fmt.Printf("%s: ", prompt)
+	answer, err := stdin.ReadString('\n')
+	if err != nil && answer == "" {
+		return ""
 	}
-	return filepath.Base(dir), nil
+	return strings.ToLower(strings.TrimSpace(answer))
 }
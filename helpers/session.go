@@ -0,0 +1,89 @@
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Session is the login session cached on disk by 'sbx login'
+type Session struct {
+	Email     string    `json:"email"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Remote    string    `json:"remote,omitempty"` // sbx server the session belongs to, empty for the local database
+}
+
+// ConfigDir returns the directory sbx keeps per-user state in (~/.sbx)
+func ConfigDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine home directory: %v", err)
+	}
+	return filepath.Join(home, ".sbx"), nil
+}
+
+func sessionPath() (string, error) {
+	dir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "session.json"), nil
+}
+
+// SaveSession writes the session to disk, readable only by the current user
+func SaveSession(session Session) error {
+	path, err := sessionPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %v", filepath.Dir(path), err)
+	}
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %v", err)
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// LoadSession reads the cached session. It returns nil when there is no
+// session or the cached one has expired.
+func LoadSession() (*Session, error) {
+	path, err := sessionPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session: %v", err)
+	}
+
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("failed to decode session: %v", err)
+	}
+	if time.Now().After(session.ExpiresAt) {
+		return nil, nil
+	}
+	return &session, nil
+}
+
+// ClearSession removes the cached session
+func ClearSession() error {
+	path, err := sessionPath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove session: %v", err)
+	}
+	return nil
+}